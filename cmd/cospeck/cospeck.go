@@ -1,8 +1,14 @@
 package cospeck
 
-import "github.com/Klaven/cospeck/pkg/cospeck/cmd"
+import (
+	"os"
+
+	"github.com/Klaven/cospeck/pkg/cospeck/cmd"
+)
 
 // Run our cmd
 func Run() {
-	cmd.RootCmd().Execute()
+	if err := cmd.RootCmd().Execute(); err != nil {
+		os.Exit(1)
+	}
 }
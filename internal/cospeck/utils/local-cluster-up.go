@@ -2,9 +2,10 @@ package utils
 
 import (
 	"bytes"
-	"fmt"
 	"os"
 	"os/exec"
+
+	log "github.com/sirupsen/logrus"
 )
 
 // TODO: low priority, but it would be nice to be able to automatically do this, might take a play from kubeadm phases
@@ -18,7 +19,7 @@ func ClusterUp(args ...string) (string, error) {
 	cmd.Stdout = &out
 	err := cmd.Run()
 	if err != nil {
-		fmt.Println(err)
+		log.WithError(err).WithField("args", args).Error("command failed")
 		return "", err
 	}
 	output := out.String()
@@ -2,9 +2,10 @@ package utils
 
 import (
 	"bytes"
-	"fmt"
 	"os"
 	"os/exec"
+
+	log "github.com/sirupsen/logrus"
 )
 
 // CrictlRunner make this suck less
@@ -15,7 +16,7 @@ func CrictlRunner(args ...string) (string, error) {
 	cmd.Stdout = &out
 	err := cmd.Run()
 	if err != nil {
-		fmt.Println(err)
+		log.WithError(err).WithField("args", args).Error("command failed")
 		return "", err
 	}
 	output := out.String()
@@ -31,7 +32,7 @@ func DockerRunner(args ...string) (string, error) {
 	cmd.Stdout = &out
 	err := cmd.Run()
 	if err != nil {
-		fmt.Println(err)
+		log.WithError(err).WithField("args", args).Error("command failed")
 		return "", err
 	}
 	output := out.String()
@@ -48,7 +49,7 @@ func KubectlRunner(args ...string) (string, error) {
 	cmd.Stdout = &out
 	err := cmd.Run()
 	if err != nil {
-		fmt.Println(err)
+		log.WithError(err).WithField("args", args).Error("command failed")
 		return "", err
 	}
 	output := out.String()
@@ -0,0 +1,4 @@
+package cospeck
+
+// Version of cospeck, overridden at build time with -ldflags "-X"
+var Version = "dev"
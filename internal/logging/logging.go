@@ -0,0 +1,59 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type contextKey int
+
+const entryKey contextKey = iota
+
+// Field names every message about a pod should carry
+const (
+	RunID    = "run"
+	PodIndex = "pod"
+	PodID    = "pod_id"
+	Phase    = "phase"
+)
+
+// Setup configures the global logger, logs always go to stderr so results on stdout stay clean
+func Setup(level, format string) error {
+	lvl, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	log.SetLevel(lvl)
+	log.SetOutput(os.Stderr)
+
+	switch format {
+	case "text":
+		log.SetFormatter(&log.TextFormatter{FullTimestamp: true})
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+	return nil
+}
+
+// WithFields returns a context whose logger carries the given fields on top of any it already had
+func WithFields(ctx context.Context, fields log.Fields) context.Context {
+	return context.WithValue(ctx, entryKey, FromContext(ctx).WithFields(fields))
+}
+
+// WithField is WithFields for a single field
+func WithField(ctx context.Context, key string, value interface{}) context.Context {
+	return WithFields(ctx, log.Fields{key: value})
+}
+
+// FromContext returns the logger for the context
+func FromContext(ctx context.Context) *log.Entry {
+	if entry, ok := ctx.Value(entryKey).(*log.Entry); ok {
+		return entry
+	}
+	return log.NewEntry(log.StandardLogger())
+}
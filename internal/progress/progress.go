@@ -0,0 +1,188 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Klaven/cospeck/internal/stats"
+	log "github.com/sirupsen/logrus"
+)
+
+// how many of the most recent latencies the rolling percentiles are taken over
+const latencyWindow = 100
+
+// Tracker keeps pod counters and redraws a single status line in place on stderr, where the logs also go.
+// While it runs log lines are written above the status line rather than through it.
+// A nil Tracker is valid and does nothing, so callers don't have to check if progress is on
+type Tracker struct {
+	created int64
+	running int64
+	failed  int64
+	stopped int64
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	next      int
+
+	memory func() (uint64, error)
+	// outMu serialises the status line and log lines, line is the last status drawn
+	outMu       sync.Mutex
+	out         io.Writer
+	line        string
+	logOut      io.Writer
+	done        chan struct{}
+	wg          sync.WaitGroup
+	lastRunning int64
+	lastDraw    time.Time
+}
+
+// New returns a tracker drawing to stderr, or nil if progress is turned off or stdout or stderr is not a terminal.
+// Stdout is checked too so a run with its results redirected to a file has no progress line.
+// memory returns the runtimes current memory usage in MiB and may be nil
+func New(enabled bool, memory func() (uint64, error)) *Tracker {
+	if !enabled || !IsTerminal(os.Stdout) || !IsTerminal(os.Stderr) {
+		return nil
+	}
+	return &Tracker{
+		memory: memory,
+		out:    os.Stderr,
+		done:   make(chan struct{}),
+	}
+}
+
+// IsTerminal returns true if the file is a character device, i.e. a TTY rather than a pipe or file
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Start redraws the status line every interval until Stop is called
+func (t *Tracker) Start(interval time.Duration) {
+	if t == nil {
+		return
+	}
+	t.lastDraw = time.Now()
+	t.logOut = log.StandardLogger().Out
+	log.SetOutput(logWriter{t})
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.done:
+				t.draw()
+				t.outMu.Lock()
+				fmt.Fprintln(t.out)
+				t.line = ""
+				t.outMu.Unlock()
+				return
+			case <-ticker.C:
+				t.draw()
+			}
+		}
+	}()
+}
+
+// Stop draws the final status and leaves it on screen
+func (t *Tracker) Stop() {
+	if t == nil {
+		return
+	}
+	close(t.done)
+	t.wg.Wait()
+	log.SetOutput(t.logOut)
+}
+
+// logWriter clears the status line before each log line and redraws it after
+type logWriter struct {
+	t *Tracker
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	w.t.outMu.Lock()
+	defer w.t.outMu.Unlock()
+	if w.t.line != "" {
+		fmt.Fprint(w.t.out, "\r\033[K")
+	}
+	n, err := w.t.logOut.Write(p)
+	fmt.Fprint(w.t.out, w.t.line)
+	return n, err
+}
+
+// Created counts a pod whose sandbox and containers were created
+func (t *Tracker) Created() {
+	if t == nil {
+		return
+	}
+	atomic.AddInt64(&t.created, 1)
+}
+
+// Running counts a pod whose containers are running, latency is how long it took from the start of creation
+func (t *Tracker) Running(latency time.Duration) {
+	if t == nil {
+		return
+	}
+	atomic.AddInt64(&t.running, 1)
+	t.mu.Lock()
+	if len(t.latencies) < latencyWindow {
+		t.latencies = append(t.latencies, latency)
+	} else {
+		t.latencies[t.next] = latency
+		t.next = (t.next + 1) % latencyWindow
+	}
+	t.mu.Unlock()
+}
+
+// Failed counts a pod that could not be created or started
+func (t *Tracker) Failed() {
+	if t == nil {
+		return
+	}
+	atomic.AddInt64(&t.failed, 1)
+}
+
+// Stopped counts a pod that was stopped
+func (t *Tracker) Stopped() {
+	if t == nil {
+		return
+	}
+	atomic.AddInt64(&t.stopped, 1)
+}
+
+func (t *Tracker) draw() {
+	now := time.Now()
+	running := atomic.LoadInt64(&t.running)
+	throughput := float64(running-t.lastRunning) / now.Sub(t.lastDraw).Seconds()
+	t.lastRunning = running
+	t.lastDraw = now
+
+	t.mu.Lock()
+	p50 := stats.Percentile(t.latencies, 50)
+	p99 := stats.Percentile(t.latencies, 99)
+	t.mu.Unlock()
+
+	line := fmt.Sprintf("created %d | running %d | failed %d | stopped %d | %.1f pods/s | p50 %s p99 %s",
+		atomic.LoadInt64(&t.created), running, atomic.LoadInt64(&t.failed), atomic.LoadInt64(&t.stopped),
+		throughput, p50.Round(time.Millisecond), p99.Round(time.Millisecond))
+
+	if t.memory != nil {
+		if mem, err := t.memory(); err == nil {
+			line += fmt.Sprintf(" | runtime %d MiB", mem)
+		}
+	}
+
+	// carriage return and clear the line so the status updates in place
+	t.outMu.Lock()
+	t.line = line
+	fmt.Fprintf(t.out, "\r\033[K%s", line)
+	t.outMu.Unlock()
+}
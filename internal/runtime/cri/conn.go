@@ -0,0 +1,180 @@
+package cri
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Klaven/cospeck/internal/logging"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// retryWindow is how long a call keeps being retried while the runtime is unavailable
+	retryWindow = 2 * time.Minute
+	// retryBackoff and maxRetryBackoff bound the wait between retries of a call
+	retryBackoff    = 100 * time.Millisecond
+	maxRetryBackoff = 5 * time.Second
+	// maxReconnectBackoff caps grpcs reconnect backoff, its default of two minutes would hide when the runtime came back
+	maxReconnectBackoff = 2 * time.Second
+)
+
+// Outage is a time the connection to the runtime was down. End is zero while it still is
+type Outage struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Duration is how long the outage lasted, or has lasted so far
+func (o Outage) Duration() time.Duration {
+	if o.End.IsZero() {
+		return time.Since(o.Start)
+	}
+	return o.End.Sub(o.Start)
+}
+
+// health follows the state of the connection to the runtime
+type health struct {
+	mu      sync.Mutex
+	ready   bool
+	outages []Outage
+}
+
+// retried are the calls that are safe to repeat after a failure that may have reached the runtime, they only read
+// or leave the same state however often they are made. Creating sandboxes and containers is not, a retry could create a second one
+var retried = map[string]bool{
+	"Version":                  true,
+	"Status":                   true,
+	"PodSandboxStatus":         true,
+	"ListPodSandbox":           true,
+	"ContainerStatus":          true,
+	"ListContainers":           true,
+	"ContainerStats":           true,
+	"ListContainerStats":       true,
+	"StopPodSandbox":           true,
+	"RemovePodSandbox":         true,
+	"StopContainer":            true,
+	"RemoveContainer":          true,
+	"UpdateContainerResources": true,
+	"ImageStatus":              true,
+	"ListImages":               true,
+	"ImageFsInfo":              true,
+	"PullImage":                true,
+}
+
+// noRetry is a call option that stops a call being retried while the runtime is unavailable
+type noRetry struct {
+	grpc.EmptyCallOption
+}
+
+// NoRetry fails a call straight away when the runtime is unavailable, for probing it
+func NoRetry() grpc.CallOption {
+	return noRetry{}
+}
+
+func getGRPCConn(socket string, timeout time.Duration, h *health) (*grpc.ClientConn, error) {
+	reconnect := backoff.DefaultConfig
+	reconnect.BaseDelay = retryBackoff
+	reconnect.MaxDelay = maxReconnectBackoff
+
+	conn, err := grpc.Dial(socket, grpc.WithInsecure(), grpc.WithTimeout(timeout),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", addr, timeout)
+		}),
+		grpc.WithConnectParams(grpc.ConnectParams{Backoff: reconnect, MinConnectTimeout: timeout}),
+		grpc.WithUnaryInterceptor(h.retryUnavailable))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %v", err)
+	}
+	return conn, nil
+}
+
+// retryUnavailable retries the calls in retried that failed because the runtime was unavailable, such as while it restarts,
+// with backoff for up to retryWindow or until the calls context is done. Nothing is retried until the connection has been
+// up once, a runtime that is down from the start fails straight away
+func (h *health) retryUnavailable(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if !retried[method[strings.LastIndex(method, "/")+1:]] || !h.wasReady() {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	for _, o := range opts {
+		if _, ok := o.(noRetry); ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+	}
+
+	deadline := time.Now().Add(retryWindow)
+	wait := retryBackoff
+	for {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if status.Code(err) != codes.Unavailable || time.Now().Add(wait).After(deadline) {
+			return err
+		}
+		logging.FromContext(ctx).WithError(err).WithField("method", method).Warnf("runtime unavailable, retrying in %s", wait)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		if wait *= 2; wait > maxRetryBackoff {
+			wait = maxRetryBackoff
+		}
+	}
+}
+
+// monitor follows the connections state until it is closed, recording every time it drops after being ready
+func (h *health) monitor(conn *grpc.ClientConn) {
+	state := conn.GetState()
+	for {
+		h.update(state)
+		if state == connectivity.Shutdown || !conn.WaitForStateChange(context.Background(), state) {
+			return
+		}
+		state = conn.GetState()
+	}
+}
+
+func (h *health) update(state connectivity.State) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	down := len(h.outages) > 0 && h.outages[len(h.outages)-1].End.IsZero()
+	switch {
+	case state == connectivity.Ready:
+		if down {
+			h.outages[len(h.outages)-1].End = time.Now()
+			log.WithField("outage", h.outages[len(h.outages)-1].Duration()).Info("reconnected to the runtime")
+		}
+		h.ready = true
+	case h.ready && !down && state != connectivity.Shutdown:
+		h.outages = append(h.outages, Outage{Start: time.Now()})
+		log.WithField("state", state.String()).Warn("lost the connection to the runtime")
+	}
+}
+
+func (h *health) wasReady() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.ready
+}
+
+// Healthy is whether the connection to the runtime is up
+func (r *Runtime) Healthy() bool {
+	r.health.mu.Lock()
+	defer r.health.mu.Unlock()
+	return r.health.ready && (len(r.health.outages) == 0 || !r.health.outages[len(r.health.outages)-1].End.IsZero())
+}
+
+// Outages returns every time the connection to the runtime dropped since it was first ready
+func (r *Runtime) Outages() []Outage {
+	r.health.mu.Lock()
+	defer r.health.mu.Unlock()
+	return append([]Outage{}, r.health.outages...)
+}
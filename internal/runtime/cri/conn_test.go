@@ -0,0 +1,66 @@
+package cri
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+func TestHealthOutages(t *testing.T) {
+	var h health
+	for _, state := range []connectivity.State{connectivity.Connecting, connectivity.Ready, connectivity.TransientFailure,
+		connectivity.Connecting, connectivity.TransientFailure, connectivity.Ready, connectivity.Connecting} {
+		h.update(state)
+	}
+
+	if len(h.outages) != 2 {
+		t.Fatalf("Expected two outages found %d", len(h.outages))
+	}
+	if h.outages[0].End.IsZero() {
+		t.Errorf("Expected the first outage to have ended")
+	}
+	if !h.outages[1].End.IsZero() {
+		t.Errorf("Expected the second outage to still be going")
+	}
+}
+
+func TestRetryUnavailable(t *testing.T) {
+	h := &health{ready: true}
+	calls := 0
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		if calls < 3 {
+			return status.Error(codes.Unavailable, "connection refused")
+		}
+		return nil
+	}
+
+	if err := h.retryUnavailable(context.Background(), "/runtime.v1alpha2.RuntimeService/ListPodSandbox", nil, nil, nil, invoker); err != nil {
+		t.Errorf("Expected the call to succeed once available, got %s", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected three calls found %d", calls)
+	}
+
+	for name, call := range map[string]struct {
+		h      *health
+		method string
+		opts   []grpc.CallOption
+	}{
+		"no retry option": {h, "/runtime.v1alpha2.RuntimeService/ListPodSandbox", []grpc.CallOption{NoRetry()}},
+		"not idempotent":  {h, "/runtime.v1alpha2.RuntimeService/RunPodSandbox", nil},
+		"never ready":     {&health{}, "/runtime.v1alpha2.RuntimeService/ListPodSandbox", nil},
+	} {
+		calls = 0
+		if err := call.h.retryUnavailable(context.Background(), call.method, nil, nil, nil, invoker, call.opts...); status.Code(err) != codes.Unavailable {
+			t.Errorf("%s: Expected an unavailable error without retries, got %v", name, err)
+		}
+		if calls != 1 {
+			t.Errorf("%s: Expected one call found %d", name, calls)
+		}
+	}
+}
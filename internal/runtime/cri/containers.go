@@ -1,6 +1,9 @@
 package cri
 
-import "github.com/Klaven/cospeck/internal/runtime"
+import (
+	criapi "github.com/Klaven/cospeck/cri"
+	"github.com/Klaven/cospeck/internal/runtime"
+)
 
 // Container is an implementation of the container metadata needed for CRI implementation
 type Container struct {
@@ -11,6 +14,10 @@ type Container struct {
 	process     string
 	trace       bool
 	containerID string
+	// config is what the container was created with, restarts create the next attempt from it
+	config *criapi.ContainerConfig
+	// logPath is the containers CRI log file on the host, empty if it isn't logged
+	logPath string
 }
 
 var _ runtime.Container = &Container{}
@@ -46,3 +53,8 @@ func (c *Container) Command() string {
 func (c *Container) ContainerID() string {
 	return c.containerID
 }
+
+// LogPath returns the containers CRI log file on the host, empty if it isn't logged
+func (c *Container) LogPath() string {
+	return c.logPath
+}
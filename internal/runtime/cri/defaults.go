@@ -0,0 +1,132 @@
+package cri
+
+// The default configs are compiled in so cospeck works from any directory. They are
+// kept identical to the files in config/, which are there to copy and edit
+
+// DefaultSandboxConfig is the base pod sandbox config, the same as config/sandbox.json
+const DefaultSandboxConfig = `{
+	"metadata": {
+		"name": "cospeck",
+		"uid": "cospeck-test-cri",
+		"namespace": "cospeck.test.cri",
+		"attempt": 1
+	},
+	"hostname": "crioctl_host",
+	"log_directory": "",
+	"dns_config": {
+		"searches": [
+			"8.8.8.8"
+		]
+	},
+	"port_mappings": [],
+	"resources": {
+		"cpu": {
+			"limits": 3,
+			"requests": 2
+		},
+		"memory": {
+			"limits": 50000000,
+			"requests": 2000000
+		}
+	},
+	"labels": {
+		"group": "test"
+	},
+	"annotations": {
+		"owner": "hmeng",
+		"security.alpha.kubernetes.io/sysctls": "kernel.shm_rmid_forced=1,net.ipv4.ip_local_port_range=1024 65000",
+		"security.alpha.kubernetes.io/unsafe-sysctls": "kernel.msgmax=8192" ,
+		"security.alpha.kubernetes.io/seccomp/pod": "unconfined"
+	},
+	"linux": {
+		"security_context": {
+			"namespace_options": {
+				"host_network": false,
+				"host_pid": false,
+				"host_ipc": false
+			},
+			"selinux_options": {
+				"user": "system_u",
+				"role": "system_r",
+				"type": "svirt_lxc_net_t",
+				"level": "s0:c4,c5"
+			}
+		}
+	}
+}
+`
+
+// DefaultContainerConfig is the base container config, the same as config/container.json
+const DefaultContainerConfig = `{
+	"metadata": {
+		"name": "cospeck",
+		"attempt": 1
+	},
+	"image": {
+		"image": "docker.io/library/alpine:latest"
+	},
+	"command": [
+		"/bin/ls"
+	],
+	"args": [],
+	"working_dir": "/",
+	"envs": [
+		{
+			"key": "PATH",
+			"value": "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+		},
+		{
+			"key": "TERM",
+			"value": "xterm"
+		}
+	],
+	"privileged": true,
+	"log_path": "",
+	"stdin": false,
+	"stdin_once": false,
+	"tty": false,
+	"linux": {
+		"resources": {
+			"cpu_period": 10000,
+			"cpu_quota": 20000,
+			"cpu_shares": 512,
+			"oom_score_adj": 30
+		},
+		"security_context": {
+			"readonly_rootfs": false,
+			"selinux_options": {
+			    "user": "system_u",
+			    "role": "system_r",
+			    "type": "svirt_lxc_net_t",
+			    "level": "s0:c4,c5"
+			},
+			"capabilities": {
+				"add_capabilities": [
+					"setuid",
+					"setgid"
+				],
+				"drop_capabilities": [
+				]
+			}
+		}
+	}
+}
+`
+
+// DefaultPodSpec is the pod used when no pod spec is given, the same as config/pod.yaml
+const DefaultPodSpec = `apiVersion: v1
+kind: Pod
+metadata:
+  name: basic-pod
+spec:
+  containers:
+    - name: web
+      image: docker.io/library/alpine:latest
+      command:
+        - sleep
+        - "5000"
+      ports:
+        - name: web
+          containerPort: 80
+          protocol: TCP
+`
@@ -1,12 +1,20 @@
 package cri
 
-import "github.com/Klaven/cospeck/internal/runtime"
+import (
+	"time"
+
+	criapi "github.com/Klaven/cospeck/cri"
+	"github.com/Klaven/cospeck/internal/runtime"
+)
 
 // Pod defaines a Pod
 type Pod struct {
 	name       string
 	podID      string
 	containers []runtime.Container
+	initTime   time.Duration
+	// sandboxConfig is what the sandbox was run with, containers are created against it
+	sandboxConfig *criapi.PodSandboxConfig
 }
 
 var _ runtime.Pod = &Pod{}
@@ -41,3 +49,29 @@ func (p *Pod) GetContainer(name string) runtime.Container {
 	}
 	return nil
 }
+
+// InitTime returns how long the pods init containers took to run to completion
+func (p *Pod) InitTime() time.Duration {
+	return p.initTime
+}
+
+// removeContainer drops a container that no longer exists on the runtime from the pod.
+// The pod gets a new slice so callers ranging over Containers are not affected
+func (p *Pod) removeContainer(old runtime.Container) {
+	kept := make([]runtime.Container, 0, len(p.containers))
+	for _, c := range p.containers {
+		if c != old {
+			kept = append(kept, c)
+		}
+	}
+	p.containers = kept
+}
+
+// replaceContainer swaps a container for its next attempt
+func (p *Pod) replaceContainer(old, next runtime.Container) {
+	for i, c := range p.containers {
+		if c == old {
+			p.containers[i] = next
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package cri
+
+import (
+	"testing"
+
+	"github.com/Klaven/cospeck/internal/runtime"
+)
+
+func TestRemoveContainer(t *testing.T) {
+	a, b, c := &Container{name: "a"}, &Container{name: "b"}, &Container{name: "c"}
+	p := &Pod{containers: []runtime.Container{a, b, c}}
+
+	seen := []string{}
+	for _, ctr := range p.Containers() {
+		if ctr == a {
+			p.removeContainer(a)
+		}
+		seen = append(seen, ctr.Name())
+	}
+	if len(seen) != 3 || seen[1] != "b" || seen[2] != "c" {
+		t.Errorf("Expected removing a container not to change a range over the old containers found %v", seen)
+	}
+	if got := p.Containers(); len(got) != 2 || got[0] != b || got[1] != c {
+		t.Errorf("Expected b and c left in the pod found %v", got)
+	}
+	if p.GetContainer("a") != nil {
+		t.Errorf("Expected the removed container not to be found")
+	}
+}
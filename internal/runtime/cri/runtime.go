@@ -2,28 +2,35 @@ package cri
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net"
+	"math"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	criapi "github.com/Klaven/cospeck/cri"
+	"github.com/Klaven/cospeck/internal/logging"
 	"github.com/Klaven/cospeck/internal/runtime"
-	log "github.com/sirupsen/logrus"
+	"github.com/Klaven/cospeck/internal/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 const (
-	defaultPauseImage      = "k8s.gcr.io/pause:3.1"
-	defaultPodNamePrefix   = "pod"
-	defaultSandboxConfig   = "config/sandbox.json"
-	defaultContainerConfig = "config/container.json"
-	defaultPodConfig       = "config/pod.yaml"
+	defaultPauseImage     = "k8s.gcr.io/pause:3.1"
+	defaultPodNamePrefix  = "pod"
+	readinessPollInterval = 100 * time.Millisecond
+	// containerWaitTimeout bounds waiting for a container to be running, or for an init container to exit
+	containerWaitTimeout = 5 * time.Minute
 )
 
 // Runtime is an implementation of the cri API
@@ -35,6 +42,18 @@ type Runtime struct {
 	baseContainerConfig *criapi.ContainerConfig
 	timeout             time.Duration
 	baseYaml            []byte
+
+	workloadsMu sync.Mutex
+	workloads   map[string]*WorkloadSet
+	// runID is handed to templated pod specs
+	runID string
+	// defaultPodSpec replaces the compiled in pod when set
+	defaultPodSpec []byte
+	// logDirectory is where pods log, in a directory per pod, empty leaves it to the sandbox config
+	logDirectory string
+
+	conn   *grpc.ClientConn
+	health *health
 }
 
 var _ runtime.Runtime = &Runtime{}
@@ -45,23 +64,20 @@ func NewCRIRuntime(path string, timeout time.Duration, podSandboxConfigReader, c
 	if path == "" {
 		return nil, fmt.Errorf("socket path unspecified")
 	}
-	var sandboxFile io.Reader
-	if podSandboxConfigReader == nil {
-		sandboxFile, _ = os.Open(defaultSandboxConfig)
-	} else {
+	var sandboxFile, containerFile io.Reader
+	if podSandboxConfigReader != nil {
 		sandboxFile = *podSandboxConfigReader
 	}
-	bsc, err := loadPodSandboxConfig(sandboxFile)
-
-	var containerFile io.Reader
-	if containerConfigReader == nil {
-		containerFile, _ = os.Open(defaultContainerConfig)
-	} else {
+	if containerConfigReader != nil {
 		containerFile = *containerConfigReader
 	}
-	bcc, err := loadContainerConfig(containerFile)
+	bsc, bcc, err := LoadConfigs(sandboxFile, containerFile)
+	if err != nil {
+		return nil, err
+	}
 
-	conn, err := getGRPCConn(path, time.Duration(10*time.Second))
+	h := &health{}
+	conn, err := getGRPCConn(path, time.Duration(10*time.Second), h)
 	if err != nil {
 		return nil, err
 	}
@@ -76,7 +92,10 @@ func NewCRIRuntime(path string, timeout time.Duration, podSandboxConfigReader, c
 		baseContainerConfig: bcc,
 		baseSandboxConfig:   bsc,
 		timeout:             timeout,
+		conn:                conn,
+		health:              h,
 	}
+	go h.monitor(conn)
 
 	return runtime, nil
 }
@@ -86,17 +105,6 @@ func (r *Runtime) GetRuntimeClient() *criapi.RuntimeServiceClient {
 	return r.runtimeClient
 }
 
-func getGRPCConn(socket string, timeout time.Duration) (*grpc.ClientConn, error) {
-	conn, err := grpc.Dial(socket, grpc.WithInsecure(), grpc.WithTimeout(timeout),
-		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
-			return net.DialTimeout("unix", addr, timeout)
-		}))
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect: %v", err)
-	}
-	return conn, nil
-}
-
 // Info returns a string with information about the container engine/runtime details
 func (r *Runtime) Info(ctx context.Context) (string, error) {
 	version, err := (*r.runtimeClient).Version(ctx, &criapi.VersionRequest{})
@@ -109,11 +117,83 @@ func (r *Runtime) Info(ctx context.Context) (string, error) {
 	return info, nil
 }
 
+// Version returns the version information reported by the runtime
+func (r *Runtime) Version(ctx context.Context) (*criapi.VersionResponse, error) {
+	return (*r.runtimeClient).Version(ctx, &criapi.VersionRequest{})
+}
+
+// Probe asks the runtime for its version without retrying, so it fails while the runtime is unavailable
+func (r *Runtime) Probe(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	_, err := (*r.runtimeClient).Version(ctx, &criapi.VersionRequest{}, NoRetry())
+	return err
+}
+
+// ListPodSandboxes returns every pod sandbox the runtime knows about
+func (r *Runtime) ListPodSandboxes(ctx context.Context) ([]*criapi.PodSandbox, error) {
+	resp, err := (*r.runtimeClient).ListPodSandbox(ctx, &criapi.ListPodSandboxRequest{Filter: &criapi.PodSandboxFilter{}})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetItems(), nil
+}
+
+// Status returns the conditions reported by the runtime
+func (r *Runtime) Status(ctx context.Context) ([]*criapi.RuntimeCondition, error) {
+	resp, err := (*r.runtimeClient).Status(ctx, &criapi.StatusRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetStatus().GetConditions(), nil
+}
+
+// ConfigHash returns a sha256 of the base sandbox and container configs so results can be tied back to them
+func (r *Runtime) ConfigHash() (string, error) {
+	opts := proto.MarshalOptions{Deterministic: true}
+	hash := sha256.New()
+	for _, m := range []proto.Message{r.baseSandboxConfig, r.baseContainerConfig} {
+		b, err := opts.Marshal(m)
+		if err != nil {
+			return "", err
+		}
+		hash.Write(b)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
 // Path returns the binary (or socket) path related to the runtime in use
 func (r *Runtime) Path() string {
 	return r.criSocketAddress
 }
 
+// ImageStatus returns the image if the runtime already has it, nil if it would have to be pulled
+func (r *Runtime) ImageStatus(ctx context.Context, image string) (*criapi.Image, error) {
+	status, err := (*r.imageClient).ImageStatus(ctx, &criapi.ImageStatusRequest{Image: &criapi.ImageSpec{Image: image}})
+	if err != nil {
+		return nil, err
+	}
+	return status.GetImage(), nil
+}
+
+// ImageFsInfo returns the usage of the filesystems the runtime keeps images and writable layers on
+func (r *Runtime) ImageFsInfo(ctx context.Context) ([]*criapi.FilesystemUsage, error) {
+	resp, err := (*r.imageClient).ImageFsInfo(ctx, &criapi.ImageFsInfoRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetImageFilesystems(), nil
+}
+
+// ListContainerStats returns the runtimes stats of every container
+func (r *Runtime) ListContainerStats(ctx context.Context) ([]*criapi.ContainerStats, error) {
+	resp, err := (*r.runtimeClient).ListContainerStats(ctx, &criapi.ListContainerStatsRequest{Filter: &criapi.ContainerStatsFilter{}})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetStats(), nil
+}
+
 // pullImage pulls an image
 func (r *Runtime) pullImage(ctx context.Context, image string) error {
 	if status, err := (*r.imageClient).ImageStatus(ctx, &criapi.ImageStatusRequest{Image: &criapi.ImageSpec{Image: image}}); err != nil || status.Image == nil {
@@ -156,59 +236,369 @@ func (r *Runtime) CreateContainer(podSandBoxID string, config *criapi.ContainerC
 
 // CreatePodAndContainerFromSpec simple helper function to create a pod and it's contaienrs from a spec
 func (r *Runtime) CreatePodAndContainerFromSpec(ctx context.Context, fileName, uid string) (runtime.Pod, error) {
-	yamlFile, err := ioutil.ReadFile(fileName)
+	set, err := r.LoadWorkloads(ctx, fileName)
 	if err != nil {
-		fmt.Printf("Error reading YAML file: %s\n", err)
 		return nil, err
 	}
-	p, con, err := ParseYamlFile(yamlFile)
 
+	// the uid is the pods number in the run, it decides which shape of the mix this pod is
+	index, _ := strconv.Atoi(uid)
+	w, err := r.workload(set, index)
 	if err != nil {
-		fmt.Println("Error Parsing Yaml: ", err)
 		return nil, err
 	}
+	p, con, initCon := w.Clone()
 
 	p.Metadata.Name = defaultPodNamePrefix + p.Metadata.Name + uid
 
-	podInfo, err := (*r.runtimeClient).RunPodSandbox(ctx, &criapi.RunPodSandboxRequest{Config: p})
+	if err := prepareEmptyDirs(p.Metadata.Name, append(con, initCon...)); err != nil {
+		r.discardEmptyDirs(ctx, p.Metadata.Name)
+		return nil, fmt.Errorf("creating emptyDir volumes: %v", err)
+	}
+	if err := prepareLogDirs(r.logDirectory, p, append(con, initCon...)); err != nil {
+		r.discardEmptyDirs(ctx, p.Metadata.Name)
+		return nil, fmt.Errorf("creating log directories: %v", err)
+	}
+
+	sctx, span := trace.Start(ctx, "sandbox.run")
+	podInfo, err := (*r.runtimeClient).RunPodSandbox(sctx, &criapi.RunPodSandboxRequest{Config: p})
+	span.SetError(err)
+	span.Finish()
 
 	if err != nil {
-		fmt.Println("Error Running Pod Sadbox: ", err)
-		return nil, err
+		r.discardEmptyDirs(ctx, p.Metadata.Name)
+		return nil, fmt.Errorf("running pod sandbox: %v", err)
 	}
 
-	containers := []runtime.Container{}
+	pod := &Pod{
+		name:          p.Metadata.Name,
+		podID:         podInfo.PodSandboxId,
+		sandboxConfig: p,
+	}
+
+	if len(initCon) > 0 {
+		pod.initTime, err = r.runInitContainers(ctx, pod, p, initCon)
+		if err != nil {
+			r.discardSandbox(ctx, pod.name, pod.podID)
+			return nil, err
+		}
+	}
 
-	for _, contain := range con {
-		r.pullImage(ctx, contain.Image.Image)
-		clone := proto.Clone(r.baseContainerConfig)
-		cconfig := criapi.ContainerConfig{}
-		proto.Merge(&cconfig, clone)
+	containers := []runtime.Container{}
 
-		cconfig.Image.Image = contain.Image.Image
-		cconfig.Command = contain.Command
-		cconfig.Metadata.Name = contain.Metadata.Name
-		_, containerID, err := r.CreateContainer(podInfo.PodSandboxId, &cconfig, p)
+	for _, cconfig := range con {
+		ictx, span := trace.Start(ctx, "image.pull")
+		span.SetAttribute("image", cconfig.Image.Image)
+		span.SetError(r.pullImage(ictx, cconfig.Image.Image))
+		span.Finish()
+
+		_, span = trace.Start(ctx, "container.create")
+		span.SetAttribute("container", cconfig.Metadata.Name)
+		_, containerID, err := r.CreateContainer(podInfo.PodSandboxId, cconfig, p)
+		span.SetError(err)
+		span.Finish()
 		if err != nil {
-			fmt.Println("error creating container: ", err)
+			logging.FromContext(ctx).WithError(err).WithField("container", cconfig.Metadata.Name).Error("unable to create container")
 			continue
 		}
 		containers = append(containers,
 			&Container{
-				name:        contain.Metadata.Name,
-				imageName:   contain.Image.Image,
+				name:        cconfig.Metadata.Name,
+				imageName:   cconfig.Image.Image,
 				containerID: containerID,
+				config:      cconfig,
+				logPath:     logPath(p, cconfig),
 			})
 	}
 
-	pod := &Pod{
-		name:       p.Metadata.Name,
-		podID:      podInfo.PodSandboxId,
-		containers: containers,
-	}
+	pod.containers = containers
 	return pod, nil
 }
 
+// LoadWorkloads parses a pod spec file into its workloads, each file is only parsed once
+func (r *Runtime) LoadWorkloads(ctx context.Context, fileName string) (*WorkloadSet, error) {
+	r.workloadsMu.Lock()
+	defer r.workloadsMu.Unlock()
+
+	if set, ok := r.workloads[fileName]; ok {
+		return set, nil
+	}
+
+	yamlFile, err := r.PodSpec(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	// a templated spec is expanded for the first pod to find the mix, each pod expands it again
+	var template []byte
+	if IsTemplate(yamlFile) {
+		template = yamlFile
+		yamlFile, err = ExpandPodSpec(template, TemplateData{Index: 0, RunID: r.runID})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fileName, err)
+		}
+	}
+
+	set, err := ParseWorkloads(yamlFile, r.baseSandboxConfig, r.baseContainerConfig)
+	if err != nil {
+		return nil, fmt.Errorf("parsing pod spec %s: %v", fileName, err)
+	}
+	set.template = template
+	logging.FromContext(ctx).WithField("file", fileName).Infof("Workload mix: %s", set)
+
+	if r.workloads == nil {
+		r.workloads = map[string]*WorkloadSet{}
+	}
+	r.workloads[fileName] = set
+	return set, nil
+}
+
+// workload returns the workload of the i-th pod, expanding the spec for this pod when it is templated
+func (r *Runtime) workload(set *WorkloadSet, index int) (*Workload, error) {
+	slot := set.slot(index)
+	if set.template == nil {
+		return set.Workloads[slot], nil
+	}
+
+	spec, err := ExpandPodSpec(set.template, TemplateData{Index: index, RunID: r.runID})
+	if err != nil {
+		return nil, err
+	}
+	expanded, err := ParseWorkloads(spec, r.baseSandboxConfig, r.baseContainerConfig)
+	if err != nil {
+		return nil, fmt.Errorf("parsing pod spec for pod %d: %v", index, err)
+	}
+	if len(expanded.Workloads) != len(set.Workloads) {
+		return nil, fmt.Errorf("pod spec for pod %d has %d workloads, the first pod had %d, a template can't change the mix", index, len(expanded.Workloads), len(set.Workloads))
+	}
+	return expanded.Workloads[slot], nil
+}
+
+// SetDefaultPodSpec replaces the pod used when no pod spec file is given, e.g. with a built in workload
+func (r *Runtime) SetDefaultPodSpec(spec []byte) {
+	r.defaultPodSpec = spec
+}
+
+// PodSpec reads a pod spec file, an empty file name is the default pod
+func (r *Runtime) PodSpec(fileName string) ([]byte, error) {
+	if fileName == "" && r.defaultPodSpec != nil {
+		return r.defaultPodSpec, nil
+	}
+	return ReadPodSpec(fileName)
+}
+
+// SetLogDirectory makes every pod log under dir, in a directory per pod like the kubelet does
+func (r *Runtime) SetLogDirectory(dir string) {
+	r.logDirectory = dir
+}
+
+// SetRunID sets the run ID templated pod specs are expanded with
+func (r *Runtime) SetRunID(id string) {
+	r.runID = id
+}
+
+// RestartTiming is how long each step of a container restart took
+type RestartTiming struct {
+	Stop   time.Duration
+	Remove time.Duration
+	Create time.Duration
+	// Start includes waiting for the container to be running
+	Start time.Duration
+	Total time.Duration
+}
+
+// RestartContainer stops and removes a container, then creates and starts its next attempt in the same sandbox,
+// the way the kubelet restarts a crashed container. The pod is updated with the new container
+func (r *Runtime) RestartContainer(ctx context.Context, pod runtime.Pod, ctr runtime.Container) (runtime.Container, RestartTiming, error) {
+	var timing RestartTiming
+	p, ok := pod.(*Pod)
+	if !ok {
+		return nil, timing, fmt.Errorf("pod %s was not created by the cri runtime", pod.Name())
+	}
+	c, ok := ctr.(*Container)
+	if !ok || c.config == nil {
+		return nil, timing, fmt.Errorf("container %s has no config to restart from", ctr.Name())
+	}
+
+	ctx, span := trace.Start(ctx, "container.restart")
+	defer span.Finish()
+	span.SetAttribute("container", c.Name())
+	start := time.Now()
+
+	step := func(name string, took *time.Duration, call func(context.Context) error) error {
+		sctx, span := trace.Start(ctx, name)
+		stepStart := time.Now()
+		err := call(sctx)
+		*took = time.Since(stepStart)
+		span.SetError(err)
+		span.Finish()
+		return err
+	}
+
+	err := step("container.stop", &timing.Stop, func(ctx context.Context) error {
+		_, err := (*r.runtimeClient).StopContainer(ctx, &criapi.StopContainerRequest{ContainerId: c.containerID})
+		return err
+	})
+	if err != nil {
+		span.SetError(err)
+		return nil, timing, fmt.Errorf("stopping container: %v", err)
+	}
+	err = step("container.remove", &timing.Remove, func(ctx context.Context) error {
+		_, err := (*r.runtimeClient).RemoveContainer(ctx, &criapi.RemoveContainerRequest{ContainerId: c.containerID})
+		return err
+	})
+	if err != nil {
+		span.SetError(err)
+		return nil, timing, fmt.Errorf("removing container: %v", err)
+	}
+
+	config := proto.Clone(c.config).(*criapi.ContainerConfig)
+	config.Metadata.Attempt++
+	if config.LogPath != "" {
+		config.LogPath = containerLogPath(config)
+	}
+	var containerID string
+	err = step("container.create", &timing.Create, func(ctx context.Context) error {
+		var err error
+		_, containerID, err = r.CreateContainer(p.podID, config, p.sandboxConfig)
+		return err
+	})
+	if err != nil {
+		// the old attempt is gone, so the pod is left without the container
+		p.removeContainer(c)
+		span.SetError(err)
+		return nil, timing, fmt.Errorf("creating attempt %d: %v", config.Metadata.Attempt, err)
+	}
+
+	next := &Container{
+		name:        c.name,
+		imageName:   c.imageName,
+		containerID: containerID,
+		config:      config,
+		logPath:     logPath(p.sandboxConfig, config),
+	}
+	p.replaceContainer(c, next)
+
+	err = step("container.start", &timing.Start, func(ctx context.Context) error {
+		if _, err := r.Run(ctx, next); err != nil {
+			return err
+		}
+		_, err := r.WaitRunning(ctx, next)
+		return err
+	})
+	timing.Total = time.Since(start)
+	if err != nil {
+		span.SetError(err)
+		return next, timing, fmt.Errorf("starting attempt %d: %v", config.Metadata.Attempt, err)
+	}
+	return next, timing, nil
+}
+
+// runInitContainers creates and runs each init container in order, waiting for it to exit successfully
+// before starting the next one. It returns how long the whole init phase took
+func (r *Runtime) runInitContainers(ctx context.Context, pod *Pod, sandbox *criapi.PodSandboxConfig, configs []*criapi.ContainerConfig) (time.Duration, error) {
+	ctx, span := trace.Start(ctx, "init")
+	defer span.Finish()
+
+	start := time.Now()
+	for _, config := range configs {
+		name := config.Metadata.Name
+		cctx, cspan := trace.Start(ctx, "init.container")
+		cspan.SetAttribute("container", name)
+
+		err := r.pullImage(cctx, config.Image.Image)
+		if err != nil {
+			err = fmt.Errorf("pulling image for init container %s: %v", name, err)
+		}
+
+		var containerID string
+		if err == nil {
+			_, containerID, err = r.CreateContainer(pod.podID, config, sandbox)
+			if err != nil {
+				err = fmt.Errorf("creating init container %s: %v", name, err)
+			}
+		}
+
+		if err == nil {
+			ctr := &Container{name: name, imageName: config.Image.Image, containerID: containerID}
+			if _, err = r.Run(cctx, ctr); err != nil {
+				err = fmt.Errorf("starting init container %s: %v", name, err)
+			} else {
+				wctx, cancel := context.WithTimeout(cctx, containerWaitTimeout)
+				_, err = r.WaitExited(wctx, ctr)
+				cancel()
+			}
+			// finished init containers are only kept until the sandbox is removed on failure
+			if err == nil {
+				if _, rerr := (*r.runtimeClient).RemoveContainer(cctx, &criapi.RemoveContainerRequest{ContainerId: containerID}); rerr != nil {
+					logging.FromContext(cctx).WithError(rerr).WithField("container", name).Warn("unable to remove init container")
+				}
+			}
+		}
+
+		cspan.SetError(err)
+		cspan.Finish()
+		if err != nil {
+			span.SetError(err)
+			return time.Since(start), err
+		}
+	}
+
+	return time.Since(start), nil
+}
+
+// discardSandbox stops and removes a sandbox that failed part way through being set up, with everything in it
+func (r *Runtime) discardSandbox(ctx context.Context, name, podID string) {
+	ctx = logging.WithField(ctx, logging.PodID, podID)
+	if _, err := (*r.runtimeClient).StopPodSandbox(ctx, &criapi.StopPodSandboxRequest{PodSandboxId: podID}); err != nil {
+		logging.FromContext(ctx).WithError(err).Error("unable to stop pod")
+	}
+	if _, err := (*r.runtimeClient).RemovePodSandbox(ctx, &criapi.RemovePodSandboxRequest{PodSandboxId: podID}); err != nil {
+		logging.FromContext(ctx).WithError(err).Error("unable to remove pod")
+	}
+	r.discardEmptyDirs(ctx, name)
+}
+
+// discardEmptyDirs removes a pods emptyDir volumes from the host, a failure is only logged
+func (r *Runtime) discardEmptyDirs(ctx context.Context, name string) {
+	if err := removeEmptyDirs(name); err != nil {
+		logging.FromContext(ctx).WithError(err).Warn("unable to remove emptyDir volumes")
+	}
+}
+
+// ContainerStatus returns what the runtime reports about a container
+func (r *Runtime) ContainerStatus(ctx context.Context, ctr runtime.Container) (*criapi.ContainerStatus, error) {
+	status, err := (*r.runtimeClient).ContainerStatus(ctx, &criapi.ContainerStatusRequest{ContainerId: ctr.ContainerID()})
+	if err != nil {
+		return nil, err
+	}
+	return status.GetStatus(), nil
+}
+
+// WaitExited blocks until the container exits, it returns an error if the container did not exit with 0
+func (r *Runtime) WaitExited(ctx context.Context, ctr runtime.Container) (time.Duration, error) {
+	start := time.Now()
+	for {
+		status, err := (*r.runtimeClient).ContainerStatus(ctx, &criapi.ContainerStatusRequest{ContainerId: ctr.ContainerID()})
+		if err != nil {
+			return time.Since(start), err
+		}
+
+		if status.GetStatus().GetState() == criapi.ContainerState_CONTAINER_EXITED {
+			if code := status.GetStatus().GetExitCode(); code != 0 {
+				return time.Since(start), fmt.Errorf("container %s exited with code %d (%s)", ctr.Name(), code, status.GetStatus().GetReason())
+			}
+			return time.Since(start), nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return time.Since(start), ctx.Err()
+		case <-time.After(readinessPollInterval):
+		}
+	}
+}
+
 // CreatePod will create a Pod with no containers to be used later
 func (r *Runtime) CreatePod(ctx context.Context, name string) (*Pod, error) {
 
@@ -239,28 +629,166 @@ func (r *Runtime) Clean(ctx context.Context) error {
 	pods := respp.GetItems()
 
 	for _, pod := range pods {
-		_, err = (*r.runtimeClient).StopPodSandbox(ctx, &criapi.StopPodSandboxRequest{PodSandboxId: pod.Id})
+		pctx := logging.WithField(ctx, logging.PodID, pod.Id)
+		_, err = (*r.runtimeClient).StopPodSandbox(pctx, &criapi.StopPodSandboxRequest{PodSandboxId: pod.Id})
 		if err != nil {
-			log.Errorf("Error deleting pod %s, %v", pod.Id, err)
+			logging.FromContext(pctx).WithError(err).Error("unable to stop pod")
 		}
-		_, err = (*r.runtimeClient).RemovePodSandbox(ctx, &criapi.RemovePodSandboxRequest{PodSandboxId: pod.Id})
+		_, err = (*r.runtimeClient).RemovePodSandbox(pctx, &criapi.RemovePodSandboxRequest{PodSandboxId: pod.Id})
 		if err != nil {
-			log.Errorf("Error deleting pod %s, %v", pod.Id, err)
+			logging.FromContext(pctx).WithError(err).Error("unable to remove pod")
+			continue
 		}
+		r.discardEmptyDirs(pctx, pod.GetMetadata().GetName())
 	}
 
-	log.Infof("CRI cleanup complete.")
+	logging.FromContext(ctx).Info("CRI cleanup complete.")
 	return nil
 }
 
 // Run will execute a container using the cri runtime
 func (r *Runtime) Run(ctx context.Context, ctr runtime.Container) (time.Duration, error) {
+	ctx, span := trace.Start(ctx, "container.start")
+	defer span.Finish()
+	span.SetAttribute("container", ctr.Name())
+
 	start := time.Now()
 	_, err := (*r.runtimeClient).StartContainer(ctx, &criapi.StartContainerRequest{ContainerId: ctr.ContainerID()})
 	elapsed := time.Since(start)
+	span.SetError(err)
 	return elapsed, err
 }
 
+// WaitRunning blocks until the runtime reports the container as running, for up to containerWaitTimeout
+func (r *Runtime) WaitRunning(ctx context.Context, ctr runtime.Container) (time.Duration, error) {
+	ctx, span := trace.Start(ctx, "readiness")
+	defer span.Finish()
+	span.SetAttribute("container", ctr.Name())
+	ctx, cancel := context.WithTimeout(ctx, containerWaitTimeout)
+	defer cancel()
+
+	start := time.Now()
+	for {
+		status, err := (*r.runtimeClient).ContainerStatus(ctx, &criapi.ContainerStatusRequest{ContainerId: ctr.ContainerID()})
+		if err != nil {
+			span.SetError(err)
+			return time.Since(start), err
+		}
+
+		switch status.GetStatus().GetState() {
+		case criapi.ContainerState_CONTAINER_RUNNING:
+			return time.Since(start), nil
+		case criapi.ContainerState_CONTAINER_EXITED:
+			err = fmt.Errorf("container %s exited with code %d before becoming ready", ctr.Name(), status.GetStatus().GetExitCode())
+			span.SetError(err)
+			return time.Since(start), err
+		}
+
+		select {
+		case <-ctx.Done():
+			err = fmt.Errorf("container %s not running after %s: %v", ctr.Name(), time.Since(start).Round(time.Millisecond), ctx.Err())
+			span.SetError(err)
+			return time.Since(start), err
+		case <-time.After(readinessPollInterval):
+		}
+	}
+}
+
+// ExecSync runs a command in a container and waits for it to exit
+func (r *Runtime) ExecSync(ctx context.Context, ctr runtime.Container, cmd []string, timeout time.Duration) (time.Duration, *criapi.ExecSyncResponse, error) {
+	start := time.Now()
+	resp, err := (*r.runtimeClient).ExecSync(ctx, &criapi.ExecSyncRequest{
+		ContainerId: ctr.ContainerID(),
+		Cmd:         cmd,
+		// in whole seconds, rounded up as 0 is no timeout
+		Timeout: int64(math.Ceil(timeout.Seconds())),
+	})
+	elapsed := time.Since(start)
+	if err != nil {
+		return elapsed, nil, err
+	}
+	if resp.GetExitCode() != 0 {
+		return elapsed, resp, fmt.Errorf("exec %v in %s exited with %d: %s", cmd, ctr.Name(), resp.GetExitCode(), resp.GetStderr())
+	}
+	return elapsed, resp, nil
+}
+
+// Exec asks the runtime for a streaming url running cmd in a container, stdin is not opened
+func (r *Runtime) Exec(ctx context.Context, ctr runtime.Container, cmd []string) (string, time.Duration, error) {
+	start := time.Now()
+	resp, err := (*r.runtimeClient).Exec(ctx, &criapi.ExecRequest{
+		ContainerId: ctr.ContainerID(),
+		Cmd:         cmd,
+		Stdout:      true,
+		Stderr:      true,
+	})
+	elapsed := time.Since(start)
+	if err != nil {
+		return "", elapsed, err
+	}
+	return resp.GetUrl(), elapsed, nil
+}
+
+// Attach asks the runtime for a streaming url attached to a containers output
+func (r *Runtime) Attach(ctx context.Context, ctr runtime.Container) (string, time.Duration, error) {
+	start := time.Now()
+	resp, err := (*r.runtimeClient).Attach(ctx, &criapi.AttachRequest{
+		ContainerId: ctr.ContainerID(),
+		Stdout:      true,
+		Stderr:      true,
+	})
+	elapsed := time.Since(start)
+	if err != nil {
+		return "", elapsed, err
+	}
+	return resp.GetUrl(), elapsed, nil
+}
+
+// PortForward asks the runtime for a streaming url forwarding to ports in a pods network namespace
+func (r *Runtime) PortForward(ctx context.Context, pod runtime.Pod, ports []int32) (string, time.Duration, error) {
+	start := time.Now()
+	resp, err := (*r.runtimeClient).PortForward(ctx, &criapi.PortForwardRequest{
+		PodSandboxId: pod.PodID(),
+		Port:         ports,
+	})
+	elapsed := time.Since(start)
+	if err != nil {
+		return "", elapsed, err
+	}
+	return resp.GetUrl(), elapsed, nil
+}
+
+// UpdateContainerResources sets new cpu and memory limits on a running container, converted the way the kubelet does,
+// and returns the resources it sent. They are kept for when the container is restarted
+func (r *Runtime) UpdateContainerResources(ctx context.Context, ctr runtime.Container, cpu, memory resource.Quantity) (*criapi.LinuxContainerResources, time.Duration, error) {
+	c, ok := ctr.(*Container)
+	if !ok || c.config == nil {
+		return nil, 0, fmt.Errorf("container %s has no config to update", ctr.Name())
+	}
+
+	ctx, span := trace.Start(ctx, "container.update")
+	defer span.Finish()
+	span.SetAttribute("container", c.Name())
+
+	resources := limitResources(c.config.GetLinux().GetResources(), cpu, memory)
+	start := time.Now()
+	_, err := (*r.runtimeClient).UpdateContainerResources(ctx, &criapi.UpdateContainerResourcesRequest{
+		ContainerId: c.containerID,
+		Linux:       resources,
+	})
+	elapsed := time.Since(start)
+	span.SetError(err)
+	if err != nil {
+		return nil, elapsed, err
+	}
+
+	if c.config.Linux == nil {
+		c.config.Linux = &criapi.LinuxContainerConfig{}
+	}
+	c.config.Linux.Resources = resources
+	return resources, elapsed, nil
+}
+
 // Stop will stop/kill a container will not stop a pod
 func (r *Runtime) Stop(ctx context.Context, ctr *Container) (string, time.Duration, error) {
 	start := time.Now()
@@ -274,12 +802,12 @@ func (r *Runtime) Stop(ctx context.Context, ctr *Container) (string, time.Durati
 		podID := ctr.GetPodSandboxId()
 		_, err := (*r.runtimeClient).StopContainer(ctx, &criapi.StopContainerRequest{ContainerId: ctr.GetId(), Timeout: 0})
 		if err != nil {
-			log.Errorf("Error Stoping container %v", err)
+			logging.FromContext(ctx).WithError(err).WithField("container", ctr.GetId()).Error("unable to stop container")
 			return "", 0, nil
 		}
 		_, err = (*r.runtimeClient).StopPodSandbox(ctx, &criapi.StopPodSandboxRequest{PodSandboxId: podID})
 		if err != nil {
-			log.Errorf("Error Stoping pod %v", err)
+			logging.FromContext(ctx).WithError(err).WithField(logging.PodID, podID).Error("unable to stop pod")
 			return "", 0, nil
 		}
 	}
@@ -289,36 +817,80 @@ func (r *Runtime) Stop(ctx context.Context, ctr *Container) (string, time.Durati
 
 // StopPod a pod, will stop all containers in the pod
 func (r *Runtime) StopPod(ctx context.Context, pod *runtime.Pod, file string) (time.Duration, error) {
+	ctx, span := trace.Start(ctx, "sandbox.stop")
+	defer span.Finish()
+
+	// when tracing the containers are stopped one by one first so each has its own span, the sandbox stop would stop
+	// them anyway. Only the sandbox call is timed so the stop time doesn't depend on tracing
+	for _, c := range tracedContainers(ctx, *pod) {
+		cctx, cspan := trace.Start(ctx, "container.stop")
+		cspan.SetAttribute("container", c.Name())
+		_, err := (*r.runtimeClient).StopContainer(cctx, &criapi.StopContainerRequest{ContainerId: c.ContainerID(), Timeout: 0})
+		cspan.SetError(err)
+		cspan.Finish()
+		if err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("container", c.Name()).Error("unable to stop container")
+		}
+	}
 	start := time.Now()
 	_, err := (*r.runtimeClient).StopPodSandbox(ctx, &criapi.StopPodSandboxRequest{PodSandboxId: (*pod).PodID()})
+	elapsed := time.Since(start)
+	span.SetError(err)
 	if err != nil {
-		log.Errorf("Error Stoping pod %v", err)
+		logging.FromContext(ctx).WithError(err).Error("unable to stop pod")
 		return 0, nil
 	}
 
-	elapsed := time.Since(start)
 	return elapsed, nil
 }
 
 // RemovePod will remove a pod sandbox
 func (r *Runtime) RemovePod(ctx context.Context, pod *runtime.Pod, file string) (time.Duration, error) {
 
-	start := time.Now()
+	ctx, span := trace.Start(ctx, "sandbox.remove")
+	defer span.Finish()
 
+	// like StopPod the containers are only removed one by one when tracing, and only the sandbox call is timed
+	for _, c := range tracedContainers(ctx, *pod) {
+		cctx, cspan := trace.Start(ctx, "container.remove")
+		cspan.SetAttribute("container", c.Name())
+		_, err := (*r.runtimeClient).RemoveContainer(cctx, &criapi.RemoveContainerRequest{ContainerId: c.ContainerID()})
+		cspan.SetError(err)
+		cspan.Finish()
+		if err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("container", c.Name()).Error("unable to remove container")
+		}
+	}
+
+	start := time.Now()
 	_, err := (*r.runtimeClient).RemovePodSandbox(ctx, &criapi.RemovePodSandboxRequest{PodSandboxId: (*pod).PodID()})
+	elapsed := time.Since(start)
+	span.SetError(err)
 	if err != nil {
-		log.Errorf("Error deleting pod %v", err)
+		logging.FromContext(ctx).WithError(err).Error("unable to remove pod")
 		return 0, nil
 	}
 
-	elapsed := time.Since(start)
+	r.discardEmptyDirs(ctx, (*pod).Name())
+
 	return elapsed, nil
 }
 
+// tracedContainers returns the pods containers when the context is traced, and none otherwise
+func tracedContainers(ctx context.Context, pod runtime.Pod) []runtime.Container {
+	if !trace.Enabled(ctx) {
+		return nil
+	}
+	return pod.Containers()
+}
+
 // Close allows the runtime to free any resources/close any
 // connections
 func (r *Runtime) Close() error {
-	return nil
+	if r.conn == nil {
+		return nil
+	}
+	return r.conn.Close()
 }
 
 // PID returns daemon process id
@@ -336,9 +908,10 @@ func (r *Runtime) Stats(ctx context.Context, ctr Container) (io.ReadCloser, erro
 	return nil, errors.New("not implemented")
 }
 
-// ProcNames returns the list of process names contributing to mem/cpu usage during overhead benchmark
+// ProcNames returns the list of process names contributing to mem/cpu usage during overhead benchmark.
+// These are the per container shims of containerd and cri-o, which often live outside the runtimes cgroup
 func (r *Runtime) ProcNames() []string {
-	return []string{}
+	return []string{"containerd-shim", "conmon"}
 }
 
 func openFile(path string) (*os.File, error) {
@@ -352,10 +925,50 @@ func openFile(path string) (*os.File, error) {
 	return f, nil
 }
 
+// LoadConfigs loads the base sandbox and container configs, a nil reader uses the compiled in default
+func LoadConfigs(sandboxConfig, containerConfig io.Reader) (*criapi.PodSandboxConfig, *criapi.ContainerConfig, error) {
+	if sandboxConfig == nil {
+		sandboxConfig = strings.NewReader(DefaultSandboxConfig)
+	}
+	if containerConfig == nil {
+		containerConfig = strings.NewReader(DefaultContainerConfig)
+	}
+
+	sandbox, err := loadPodSandboxConfig(sandboxConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading pod sandbox config: %v", err)
+	}
+	container, err := loadContainerConfig(containerConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading container config: %v", err)
+	}
+	return sandbox, container, nil
+}
+
+// ReadPodSpec reads a pod spec file, an empty file name is the compiled in default pod
+func ReadPodSpec(fileName string) ([]byte, error) {
+	if fileName == "" {
+		return []byte(DefaultPodSpec), nil
+	}
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("reading pod spec %s: %v", fileName, err)
+	}
+	return b, nil
+}
+
+// configUnmarshal reads configs by their proto field names or json names and enums by name or number,
+// fields the CRI version doesn't have are ignored
+var configUnmarshal = protojson.UnmarshalOptions{DiscardUnknown: true}
+
 func loadPodSandboxConfig(file io.Reader) (*criapi.PodSandboxConfig, error) {
 	var config criapi.PodSandboxConfig
 
-	if err := json.NewDecoder(file).Decode(&config); err != nil {
+	b, err := ioutil.ReadAll(file)
+	if err != nil {
+		return &criapi.PodSandboxConfig{}, err
+	}
+	if err := configUnmarshal.Unmarshal(b, &config); err != nil {
 		return &criapi.PodSandboxConfig{}, err
 	}
 	return &config, nil
@@ -364,7 +977,11 @@ func loadPodSandboxConfig(file io.Reader) (*criapi.PodSandboxConfig, error) {
 func loadContainerConfig(file io.Reader) (*criapi.ContainerConfig, error) {
 	var config criapi.ContainerConfig
 
-	if err := json.NewDecoder(file).Decode(&config); err != nil {
+	b, err := ioutil.ReadAll(file)
+	if err != nil {
+		return &criapi.ContainerConfig{}, err
+	}
+	if err := configUnmarshal.Unmarshal(b, &config); err != nil {
 		return &criapi.ContainerConfig{}, err
 	}
 	return &config, nil
@@ -0,0 +1,85 @@
+package cri
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"text/template"
+)
+
+// TemplateData is what a templated pod spec is expanded with, once for every pod
+type TemplateData struct {
+	// Index is the pods number in the run, starting at 0
+	Index int
+	// RunID is the same for every pod of a run
+	RunID string
+}
+
+// TemplateMarker is the line that makes a pod spec a template, without it a spec is used as it is even if it has "{{" in it
+const TemplateMarker = "# cospeck:template"
+
+// IsTemplate reports whether a pod spec has to be expanded before it can be parsed
+func IsTemplate(spec []byte) bool {
+	for _, line := range bytes.Split(spec, []byte("\n")) {
+		if string(bytes.TrimSpace(line)) == TemplateMarker {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpandPodSpec expands a go template pod spec for one pod. The random functions are seeded
+// from the run ID and index, so a pods spec can be reproduced from the run metadata
+func ExpandPodSpec(spec []byte, data TemplateData) ([]byte, error) {
+	seed := fnv.New64a()
+	fmt.Fprintf(seed, "%s/%d", data.RunID, data.Index)
+	rng := rand.New(rand.NewSource(int64(seed.Sum64())))
+
+	tmpl, err := template.New("pod").Funcs(templateFuncs(rng)).Option("missingkey=error").Parse(string(spec))
+	if err != nil {
+		return nil, fmt.Errorf("parsing pod spec template: %v", err)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return nil, fmt.Errorf("expanding pod spec template: %v", err)
+	}
+	return out.Bytes(), nil
+}
+
+const randLetters = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+func templateFuncs(rng *rand.Rand) template.FuncMap {
+	return template.FuncMap{
+		// randInt returns a number in [min, max)
+		"randInt": func(min, max int) (int, error) {
+			if max <= min {
+				return 0, fmt.Errorf("randInt: max %d must be more than min %d", max, min)
+			}
+			return min + rng.Intn(max-min), nil
+		},
+		// randString returns n random lower case letters and digits, safe in names and labels
+		"randString": func(n int) string {
+			b := make([]byte, n)
+			for i := range b {
+				b[i] = randLetters[rng.Intn(len(randLetters))]
+			}
+			return string(b)
+		},
+		// randChoice returns one of its arguments
+		"randChoice": func(choices ...interface{}) (interface{}, error) {
+			if len(choices) == 0 {
+				return nil, fmt.Errorf("randChoice: nothing to choose from")
+			}
+			return choices[rng.Intn(len(choices))], nil
+		},
+		"add": func(a, b int) int { return a + b },
+		"mul": func(a, b int) int { return a * b },
+		"mod": func(a, b int) (int, error) {
+			if b == 0 {
+				return 0, fmt.Errorf("mod: division by zero")
+			}
+			return a % b, nil
+		},
+	}
+}
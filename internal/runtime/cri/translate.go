@@ -0,0 +1,379 @@
+package cri
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	criapi "github.com/Klaven/cospeck/cri"
+	"google.golang.org/protobuf/proto"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// emptyDirRoot is where emptyDir volumes are created on the host, one directory per pod
+	emptyDirRoot = "/var/lib/cospeck/emptydir"
+
+	// the same conversions the kubelet uses for cpu requests and limits
+	cpuPeriod       = 100000
+	minCPUShares    = 2
+	minCPUQuota     = 1000
+	sharesPerCPU    = 1024
+	milliCPUToCPU   = 1000
+	defaultProtocol = criapi.Protocol_TCP
+)
+
+// translatePod turns a kubernetes pod into a sandbox config, container configs and init container configs, starting from the base configs
+func translatePod(spec *v1.Pod, baseSandbox *criapi.PodSandboxConfig, baseContainer *criapi.ContainerConfig) (*criapi.PodSandboxConfig, []*criapi.ContainerConfig, []*criapi.ContainerConfig) {
+	pod := proto.Clone(baseSandbox).(*criapi.PodSandboxConfig)
+	if pod.Metadata == nil {
+		pod.Metadata = &criapi.PodSandboxMetadata{}
+	}
+	pod.Metadata.Name = spec.Name
+	if spec.Namespace != "" {
+		pod.Metadata.Namespace = spec.Namespace
+	}
+	if spec.Spec.Hostname != "" {
+		pod.Hostname = spec.Spec.Hostname
+	}
+	pod.Labels = merge(pod.Labels, spec.Labels)
+	pod.Annotations = merge(pod.Annotations, spec.Annotations)
+
+	namespaces := namespaceOptions(&spec.Spec)
+	if pod.Linux == nil {
+		pod.Linux = &criapi.LinuxPodSandboxConfig{}
+	}
+	if pod.Linux.SecurityContext == nil {
+		pod.Linux.SecurityContext = &criapi.LinuxSandboxSecurityContext{}
+	}
+	pod.Linux.SecurityContext.NamespaceOptions = namespaces
+	if psc := spec.Spec.SecurityContext; psc != nil {
+		if psc.RunAsUser != nil {
+			pod.Linux.SecurityContext.RunAsUser = &criapi.Int64Value{Value: *psc.RunAsUser}
+		}
+		if psc.RunAsGroup != nil {
+			pod.Linux.SecurityContext.RunAsGroup = &criapi.Int64Value{Value: *psc.RunAsGroup}
+		}
+		pod.Linux.SecurityContext.SupplementalGroups = append(pod.Linux.SecurityContext.SupplementalGroups, psc.SupplementalGroups...)
+		if psc.FSGroup != nil {
+			pod.Linux.SecurityContext.SupplementalGroups = append(pod.Linux.SecurityContext.SupplementalGroups, *psc.FSGroup)
+		}
+	}
+
+	translate := func(specs []v1.Container) []*criapi.ContainerConfig {
+		containers := []*criapi.ContainerConfig{}
+		for i := range specs {
+			c := translateContainer(&spec.Spec, &specs[i], baseContainer)
+			c.Linux.SecurityContext.NamespaceOptions = proto.Clone(namespaces).(*criapi.NamespaceOption)
+			c.Labels = merge(c.Labels, spec.Labels)
+			containers = append(containers, c)
+		}
+		return containers
+	}
+
+	containers := translate(spec.Spec.Containers)
+	initContainers := translate(spec.Spec.InitContainers)
+	// like the kubelet only the app containers ports are mapped
+	for i := range spec.Spec.Containers {
+		pod.PortMappings = append(pod.PortMappings, portMappings(spec.Spec.Containers[i].Ports)...)
+	}
+
+	return pod, containers, initContainers
+}
+
+// translateContainer turns a kubernetes container into a container config, starting from the base config
+func translateContainer(podSpec *v1.PodSpec, container *v1.Container, baseContainer *criapi.ContainerConfig) *criapi.ContainerConfig {
+	c := proto.Clone(baseContainer).(*criapi.ContainerConfig)
+	if c.Metadata == nil {
+		c.Metadata = &criapi.ContainerMetadata{}
+	}
+	if c.Image == nil {
+		c.Image = &criapi.ImageSpec{}
+	}
+	c.Metadata.Name = container.Name
+	c.Image.Image = container.Image
+	c.Command = container.Command
+	c.Args = container.Args
+	if container.WorkingDir != "" {
+		c.WorkingDir = container.WorkingDir
+	}
+	c.Envs = envs(c.Envs, container.Env)
+	c.Mounts = append(c.Mounts, mounts(podSpec.Volumes, container.VolumeMounts)...)
+
+	if c.Linux == nil {
+		c.Linux = &criapi.LinuxContainerConfig{}
+	}
+	if c.Linux.Resources == nil {
+		c.Linux.Resources = &criapi.LinuxContainerResources{}
+	}
+	if c.Linux.SecurityContext == nil {
+		c.Linux.SecurityContext = &criapi.LinuxContainerSecurityContext{}
+	}
+	resources(c.Linux.Resources, container.Resources)
+	securityContext(c.Linux.SecurityContext, podSpec.SecurityContext, container.SecurityContext)
+
+	return c
+}
+
+func merge(base, extra map[string]string) map[string]string {
+	if len(extra) == 0 {
+		return base
+	}
+	if base == nil {
+		base = map[string]string{}
+	}
+	for k, v := range extra {
+		base[k] = v
+	}
+	return base
+}
+
+func namespaceOptions(spec *v1.PodSpec) *criapi.NamespaceOption {
+	mode := func(host bool) criapi.NamespaceMode {
+		if host {
+			return criapi.NamespaceMode_NODE
+		}
+		return criapi.NamespaceMode_POD
+	}
+
+	pid := mode(spec.HostPID)
+	if pid == criapi.NamespaceMode_POD && (spec.ShareProcessNamespace == nil || !*spec.ShareProcessNamespace) {
+		pid = criapi.NamespaceMode_CONTAINER
+	}
+
+	return &criapi.NamespaceOption{
+		Network: mode(spec.HostNetwork),
+		Pid:     pid,
+		Ipc:     mode(spec.HostIPC),
+	}
+}
+
+// envs overrides the base environment with the containers, env from references are not supported
+func envs(base []*criapi.KeyValue, env []v1.EnvVar) []*criapi.KeyValue {
+	for _, e := range env {
+		if e.ValueFrom != nil {
+			continue
+		}
+		replaced := false
+		for _, kv := range base {
+			if kv.Key == e.Name {
+				kv.Value = e.Value
+				replaced = true
+			}
+		}
+		if !replaced {
+			base = append(base, &criapi.KeyValue{Key: e.Name, Value: e.Value})
+		}
+	}
+	return base
+}
+
+// mounts turns the containers emptyDir and hostPath volume mounts into cri mounts, other volume types are skipped
+func mounts(volumes []v1.Volume, volumeMounts []v1.VolumeMount) []*criapi.Mount {
+	out := []*criapi.Mount{}
+	for _, vm := range volumeMounts {
+		var hostPath string
+		for _, v := range volumes {
+			if v.Name != vm.Name {
+				continue
+			}
+			switch {
+			case v.HostPath != nil:
+				hostPath = v.HostPath.Path
+			case v.EmptyDir != nil:
+				hostPath = filepath.Join(emptyDirRoot, v.Name)
+			}
+		}
+		if hostPath == "" {
+			continue
+		}
+		if vm.SubPath != "" {
+			hostPath = filepath.Join(hostPath, vm.SubPath)
+		}
+
+		m := &criapi.Mount{
+			ContainerPath: vm.MountPath,
+			HostPath:      hostPath,
+			Readonly:      vm.ReadOnly,
+		}
+		if vm.MountPropagation != nil {
+			switch *vm.MountPropagation {
+			case v1.MountPropagationHostToContainer:
+				m.Propagation = criapi.MountPropagation_PROPAGATION_HOST_TO_CONTAINER
+			case v1.MountPropagationBidirectional:
+				m.Propagation = criapi.MountPropagation_PROPAGATION_BIDIRECTIONAL
+			}
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// prepareEmptyDirs gives each pod its own emptyDir directories and creates them on the host
+func prepareEmptyDirs(podName string, containers []*criapi.ContainerConfig) error {
+	for _, c := range containers {
+		for _, m := range c.Mounts {
+			if !strings.HasPrefix(m.HostPath, emptyDirRoot+"/") {
+				continue
+			}
+			m.HostPath = filepath.Join(emptyDirRoot, podName, strings.TrimPrefix(m.HostPath, emptyDirRoot+"/"))
+			if err := os.MkdirAll(m.HostPath, 0755); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// removeEmptyDirs removes a pods emptyDir directories from the host. Clean passes the name of every sandbox on the node,
+// so a name that isn't a single path element is refused rather than removing anything outside emptyDirRoot
+func removeEmptyDirs(podName string) error {
+	if podName == "" {
+		return nil
+	}
+	if podName == "." || podName == ".." || strings.ContainsRune(podName, filepath.Separator) {
+		return fmt.Errorf("pod name %q is not a directory name", podName)
+	}
+	return os.RemoveAll(filepath.Join(emptyDirRoot, podName))
+}
+
+// prepareLogDirs points the sandbox and its containers at a log directory for the pod under root and creates it,
+// each container logs to <name>/<attempt>.log like under the kubelet. An empty root leaves the configs as they are
+func prepareLogDirs(root string, sandbox *criapi.PodSandboxConfig, containers []*criapi.ContainerConfig) error {
+	if root == "" {
+		return nil
+	}
+	sandbox.LogDirectory = filepath.Join(root, sandbox.Metadata.Name)
+	for _, c := range containers {
+		c.LogPath = containerLogPath(c)
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(sandbox.LogDirectory, c.LogPath)), 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// containerLogPath is where a container logs relative to its pods log directory
+func containerLogPath(c *criapi.ContainerConfig) string {
+	return filepath.Join(c.Metadata.Name, fmt.Sprintf("%d.log", c.Metadata.Attempt))
+}
+
+// logPath is the full path of a containers log file, empty if it isn't logged
+func logPath(sandbox *criapi.PodSandboxConfig, c *criapi.ContainerConfig) string {
+	if sandbox.GetLogDirectory() == "" || c.GetLogPath() == "" {
+		return ""
+	}
+	return filepath.Join(sandbox.LogDirectory, c.LogPath)
+}
+
+func portMappings(ports []v1.ContainerPort) []*criapi.PortMapping {
+	out := []*criapi.PortMapping{}
+	for _, p := range ports {
+		protocol := defaultProtocol
+		if value, ok := criapi.Protocol_value[string(p.Protocol)]; ok {
+			protocol = criapi.Protocol(value)
+		}
+		out = append(out, &criapi.PortMapping{
+			Protocol:      protocol,
+			ContainerPort: p.ContainerPort,
+			HostPort:      p.HostPort,
+			HostIp:        p.HostIP,
+		})
+	}
+	return out
+}
+
+// resources converts requests and limits the same way the kubelet does, anything unset keeps the base value
+func resources(r *criapi.LinuxContainerResources, req v1.ResourceRequirements) {
+	if cpu, ok := req.Requests[v1.ResourceCPU]; ok {
+		r.CpuShares = milliCPUToShares(cpu)
+	}
+	if cpu, ok := req.Limits[v1.ResourceCPU]; ok {
+		if _, ok := req.Requests[v1.ResourceCPU]; !ok {
+			// like the api server, a limit without a request is also the request
+			r.CpuShares = milliCPUToShares(cpu)
+		}
+		r.CpuPeriod = cpuPeriod
+		r.CpuQuota = cpu.MilliValue() * cpuPeriod / milliCPUToCPU
+		if r.CpuQuota < minCPUQuota {
+			r.CpuQuota = minCPUQuota
+		}
+	}
+	if mem, ok := req.Limits[v1.ResourceMemory]; ok {
+		r.MemoryLimitInBytes = mem.Value()
+	}
+}
+
+// limitResources returns a copy of base with cpu and memory limits, converted as for a container with only limits set
+func limitResources(base *criapi.LinuxContainerResources, cpu, memory resource.Quantity) *criapi.LinuxContainerResources {
+	r := &criapi.LinuxContainerResources{}
+	if base != nil {
+		r = proto.Clone(base).(*criapi.LinuxContainerResources)
+	}
+	resources(r, v1.ResourceRequirements{Limits: v1.ResourceList{v1.ResourceCPU: cpu, v1.ResourceMemory: memory}})
+	return r
+}
+
+func milliCPUToShares(cpu resource.Quantity) int64 {
+	shares := cpu.MilliValue() * sharesPerCPU / milliCPUToCPU
+	if shares < minCPUShares {
+		return minCPUShares
+	}
+	return shares
+}
+
+// securityContext applies the pod and then the container security context, the container wins
+func securityContext(sc *criapi.LinuxContainerSecurityContext, pod *v1.PodSecurityContext, container *v1.SecurityContext) {
+	if pod != nil {
+		if pod.RunAsUser != nil {
+			sc.RunAsUser = &criapi.Int64Value{Value: *pod.RunAsUser}
+		}
+		if pod.RunAsGroup != nil {
+			sc.RunAsGroup = &criapi.Int64Value{Value: *pod.RunAsGroup}
+		}
+		sc.SupplementalGroups = append(sc.SupplementalGroups, pod.SupplementalGroups...)
+		if pod.FSGroup != nil {
+			sc.SupplementalGroups = append(sc.SupplementalGroups, *pod.FSGroup)
+		}
+	}
+
+	if container == nil {
+		return
+	}
+	if container.Privileged != nil {
+		sc.Privileged = *container.Privileged
+	}
+	if container.RunAsUser != nil {
+		sc.RunAsUser = &criapi.Int64Value{Value: *container.RunAsUser}
+	}
+	if container.RunAsGroup != nil {
+		sc.RunAsGroup = &criapi.Int64Value{Value: *container.RunAsGroup}
+	}
+	if container.ReadOnlyRootFilesystem != nil {
+		sc.ReadonlyRootfs = *container.ReadOnlyRootFilesystem
+	}
+	if container.AllowPrivilegeEscalation != nil {
+		sc.NoNewPrivs = !*container.AllowPrivilegeEscalation
+	}
+	if container.Capabilities != nil {
+		if sc.Capabilities == nil {
+			sc.Capabilities = &criapi.Capability{}
+		}
+		for _, c := range container.Capabilities.Add {
+			sc.Capabilities.AddCapabilities = append(sc.Capabilities.AddCapabilities, string(c))
+		}
+		for _, c := range container.Capabilities.Drop {
+			sc.Capabilities.DropCapabilities = append(sc.Capabilities.DropCapabilities, string(c))
+		}
+	}
+	if container.SELinuxOptions != nil {
+		sc.SelinuxOptions = &criapi.SELinuxOption{
+			User:  container.SELinuxOptions.User,
+			Role:  container.SELinuxOptions.Role,
+			Type:  container.SELinuxOptions.Type,
+			Level: container.SELinuxOptions.Level,
+		}
+	}
+}
@@ -4,11 +4,12 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"os"
+	"strings"
 	"time"
 
 	criapi "github.com/Klaven/cospeck/cri"
 	"github.com/ghodss/yaml"
+	log "github.com/sirupsen/logrus"
 	v1 "k8s.io/api/core/v1"
 )
 
@@ -21,52 +22,36 @@ func getContextWithTimeout(timeout time.Duration) (context.Context, context.Canc
 	return context.WithTimeout(context.Background(), timeout)
 }
 
-// ParseYamlFile parses a pods yaml file
-func ParseYamlFile(file []byte) (*criapi.PodSandboxConfig, []criapi.ContainerConfig, error) {
-	r, err := os.Open(defaultSandboxConfig)
+// ParseYamlFile parses a pods yaml file using the default sandbox and container configs
+func ParseYamlFile(file []byte) (*criapi.PodSandboxConfig, []*criapi.ContainerConfig, error) {
+	return ParseYamlFileWithPodConfig(file, strings.NewReader(DefaultSandboxConfig), strings.NewReader(DefaultContainerConfig))
+}
+
+// ParseYamlFileWithPodConfig parses a pods yaml file
+func ParseYamlFileWithPodConfig(file []byte, sandboxConfig, containerConfig io.Reader) (*criapi.PodSandboxConfig, []*criapi.ContainerConfig, error) {
+	pod, err := loadPodSandboxConfig(sandboxConfig)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, fmt.Errorf("loading pod sandbox config: %v", err)
 	}
 
-	rc, err := os.Open(defaultContainerConfig)
+	container, err := loadContainerConfig(containerConfig)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, fmt.Errorf("loading container config: %v", err)
 	}
 
-	return ParseYamlFileWithPodConfig(file, r, rc)
+	return parsePodSpec(file, pod, container)
 }
 
-// ParseYamlFileWithPodConfig parses a pods yaml file
-func ParseYamlFileWithPodConfig(file []byte, sandboxConfig, containerConfig io.Reader) (*criapi.PodSandboxConfig, []criapi.ContainerConfig, error) {
-
+// parsePodSpec parses a pods yaml file on top of the base sandbox and container configs
+func parsePodSpec(file []byte, baseSandbox *criapi.PodSandboxConfig, baseContainer *criapi.ContainerConfig) (*criapi.PodSandboxConfig, []*criapi.ContainerConfig, error) {
 	var spec v1.Pod
-	err := yaml.Unmarshal(file, &spec)
-	if err != nil {
-		panic(err.Error())
+	if err := yaml.Unmarshal(file, &spec); err != nil {
+		return nil, nil, fmt.Errorf("parsing pod spec: %v", err)
 	}
-	pod, err := loadPodSandboxConfig(sandboxConfig)
-	if err != nil {
-		fmt.Println("error loading pod sandbox config")
-		return nil, nil, err
-	}
-	containers := []criapi.ContainerConfig{}
-
-	pod.Metadata.Name = spec.Name
-
-	for _, container := range spec.Spec.Containers {
-		c, err := loadContainerConfig(containerConfig)
-		if err != nil {
-			fmt.Println("error loading container config")
-			return nil, nil, err
-		}
-		c.Args = container.Args
-		c.Command = container.Command
-		c.Image.Image = container.Image
-		c.Metadata.Name = container.Name
-
-		fmt.Println("image to start: ", container.Image)
 
-		containers = append(containers, *c)
+	pod, containers, _ := translatePod(&spec, baseSandbox, baseContainer)
+	for _, c := range containers {
+		log.WithField("image", c.Image.Image).Debug("image to start")
 	}
 
 	return pod, containers, nil
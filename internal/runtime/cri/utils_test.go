@@ -1,16 +1,16 @@
 package cri
 
 import (
-	"io"
 	"io/ioutil"
 	"strings"
 	"testing"
+
+	criapi "github.com/Klaven/cospeck/cri"
 )
 
 func TestParseYamlFile(t *testing.T) {
 
-	bytes, err := ioutil.ReadAll(pod)
-	pod, containers, err := ParseYamlFileWithPodConfig(bytes, sandboxConfig, containerConfig)
+	pod, containers, err := ParseYamlFileWithPodConfig([]byte(pod), strings.NewReader(sandboxConfig), strings.NewReader(containerConfig))
 	if err != nil {
 		t.Errorf("Error parsing YAML file: %s\n", err)
 	}
@@ -29,7 +29,351 @@ func TestParseYamlFile(t *testing.T) {
 
 }
 
-var pod io.Reader = strings.NewReader(`apiVersion: v1
+func TestDefaultsMatchConfigFiles(t *testing.T) {
+	defaults := map[string]string{
+		"../../../config/sandbox.json":   DefaultSandboxConfig,
+		"../../../config/container.json": DefaultContainerConfig,
+		"../../../config/pod.yaml":       DefaultPodSpec,
+	}
+	for file, def := range defaults {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Error reading %s: %s", file, err)
+		}
+		if strings.TrimSpace(string(b)) != strings.TrimSpace(def) {
+			t.Errorf("Compiled in default differs from %s", file)
+		}
+	}
+}
+
+func TestParseYamlFileTranslatesPodSpec(t *testing.T) {
+	pod, containers, err := ParseYamlFileWithPodConfig([]byte(fullPod), strings.NewReader(sandboxConfig), strings.NewReader(containerConfig))
+	if err != nil {
+		t.Fatalf("Error parsing YAML file: %s\n", err)
+	}
+
+	if len(containers) != 2 {
+		t.Fatalf("Expected two containers found %d", len(containers))
+	}
+	web := containers[0]
+
+	if strings.Join(web.Args, " ") != "-c sleep 5000" {
+		t.Errorf("Expected args '-c sleep 5000' found '%v'", web.Args)
+	}
+
+	if web.WorkingDir != "/srv" {
+		t.Errorf("Expected working dir '/srv' found '%s'", web.WorkingDir)
+	}
+
+	envs := map[string]string{}
+	for _, e := range web.Envs {
+		envs[e.Key] = e.Value
+	}
+	if envs["MODE"] != "bench" || envs["TERM"] != "dumb" || envs["PATH"] == "" {
+		t.Errorf("Expected MODE and TERM from the spec merged with the base PATH found %v", envs)
+	}
+
+	res := web.Linux.Resources
+	if res.CpuShares != 256 || res.CpuQuota != 50000 || res.CpuPeriod != 100000 || res.MemoryLimitInBytes != 64*1024*1024 {
+		t.Errorf("Expected shares 256, quota 50000/100000 and 64Mi memory found %+v", res)
+	}
+	if res.OomScoreAdj != 30 {
+		t.Errorf("Expected base oom score adj to be kept found %d", res.OomScoreAdj)
+	}
+
+	sc := web.Linux.SecurityContext
+	if sc.RunAsUser.GetValue() != 1000 || sc.RunAsGroup.GetValue() != 3000 || !sc.ReadonlyRootfs || !sc.NoNewPrivs {
+		t.Errorf("Expected user 1000 from the container, group 3000 from the pod, readonly rootfs and no new privs found %+v", sc)
+	}
+	if sc.NamespaceOptions.Network != criapi.NamespaceMode_NODE || sc.NamespaceOptions.Ipc != criapi.NamespaceMode_POD {
+		t.Errorf("Expected host network and pod ipc found %+v", sc.NamespaceOptions)
+	}
+	if containers[1].Linux.SecurityContext.RunAsUser.GetValue() != 2000 {
+		t.Errorf("Expected sidecar to inherit pod user 2000")
+	}
+
+	if len(web.Mounts) != 2 {
+		t.Fatalf("Expected two mounts found %d", len(web.Mounts))
+	}
+	if web.Mounts[0].HostPath != emptyDirRoot+"/cache" || web.Mounts[0].ContainerPath != "/cache" {
+		t.Errorf("Expected emptyDir mount of /cache found %+v", web.Mounts[0])
+	}
+	if web.Mounts[1].HostPath != "/var/log/app" || !web.Mounts[1].Readonly {
+		t.Errorf("Expected read only hostPath mount of /var/log found %+v", web.Mounts[1])
+	}
+
+	if len(pod.PortMappings) != 1 || pod.PortMappings[0].ContainerPort != 80 || pod.PortMappings[0].HostPort != 8080 {
+		t.Errorf("Expected port mapping 8080:80 found %v", pod.PortMappings)
+	}
+	if pod.Labels["app"] != "web" || pod.Labels["group"] != "test" || pod.Annotations["team"] != "perf" {
+		t.Errorf("Expected spec labels and annotations merged with the base config found %v %v", pod.Labels, pod.Annotations)
+	}
+	if web.Labels["app"] != "web" || containers[1].Labels["app"] != "web" {
+		t.Errorf("Expected the pod labels on every container found %v %v", web.Labels, containers[1].Labels)
+	}
+	if pod.Linux.SecurityContext.NamespaceOptions.Network != criapi.NamespaceMode_NODE {
+		t.Errorf("Expected sandbox on the host network")
+	}
+}
+
+func TestParseWorkloads(t *testing.T) {
+	sandbox, _ := loadPodSandboxConfig(strings.NewReader(sandboxConfig))
+	container, _ := loadContainerConfig(strings.NewReader(containerConfig))
+
+	set, err := ParseWorkloads([]byte(mixedWorkloads), sandbox, container)
+	if err != nil {
+		t.Fatalf("Error parsing workloads: %s", err)
+	}
+
+	if len(set.Workloads) != 3 {
+		t.Fatalf("Expected three workloads (scaled to zero skipped) found %d", len(set.Workloads))
+	}
+
+	weights := map[string]int{}
+	for _, w := range set.Workloads {
+		weights[w.Name+"/"+w.Kind] = w.Weight
+	}
+	expected := map[string]int{"basic-pod/Pod": 1, "web/Deployment": 3, "batch/Job": 2}
+	for name, weight := range expected {
+		if weights[name] != weight {
+			t.Errorf("Expected %s with weight %d found %v", name, weight, weights)
+		}
+	}
+
+	picked := map[string]int{}
+	for i := 0; i < 12; i++ {
+		picked[set.Pick(i).Name]++
+	}
+	if picked["basic-pod"] != 2 || picked["web"] != 6 || picked["batch"] != 4 {
+		t.Errorf("Expected 12 pods split 2/6/4 found %v", picked)
+	}
+
+	first := []string{set.Pick(0).Name, set.Pick(1).Name, set.Pick(2).Name}
+	if strings.Join(first, ",") != "web,batch,basic-pod" {
+		t.Errorf("Expected the heaviest workload first and shapes interleaved found %v", first)
+	}
+
+	web := set.Workloads[1]
+	if web.Sandbox.Labels["app"] != "web" || web.Containers[0].Image.Image != "docker.io/library/nginx:alpine" {
+		t.Errorf("Expected the deployments pod template to be used found %v %s", web.Sandbox.Labels, web.Containers[0].Image.Image)
+	}
+
+	batch := set.Workloads[2]
+	if len(batch.InitContainers) != 2 || batch.InitContainers[0].Metadata.Name != "migrate" || batch.InitContainers[1].Metadata.Name != "warm-cache" {
+		t.Errorf("Expected init containers migrate then warm-cache found %v", batch.InitContainers)
+	}
+	if len(batch.Containers) != 1 || len(web.InitContainers) != 0 {
+		t.Errorf("Expected init containers to be kept apart from app containers")
+	}
+
+	if _, err := ParseWorkloads([]byte("kind: Service\napiVersion: v1"), sandbox, container); err == nil {
+		t.Errorf("Expected an error for unsupported kinds")
+	}
+}
+
+func TestValidatePodSpec(t *testing.T) {
+	issues, err := ValidatePodSpec([]byte(mixedWorkloads + `
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: typo
+spec:
+  nodeSelector:
+    disk: ssd
+  containers:
+    - name: app
+      imagee: busybox
+      livenessProbe:
+        exec:
+          command: ["true"]
+`))
+	if err != nil {
+		t.Fatalf("Error validating pod spec: %s", err)
+	}
+
+	found := map[string]bool{}
+	for _, i := range issues {
+		found[i.String()] = true
+	}
+	expected := []string{
+		"document 5: unknown field spec.containers[0].imagee",
+		"document 5: spec.containers[0].livenessProbe is not supported and is ignored",
+		"document 5: spec.nodeSelector is not supported and is ignored",
+	}
+	for _, e := range expected {
+		if !found[e] {
+			t.Errorf("Expected issue %q found %v", e, issues)
+		}
+	}
+
+	if _, err := ValidatePodSpec([]byte("kind: Service\n")); err == nil {
+		t.Errorf("Expected an error for an unsupported kind")
+	}
+}
+
+func TestTemplatedPodSpec(t *testing.T) {
+	sandbox, _ := loadPodSandboxConfig(strings.NewReader(sandboxConfig))
+	container, _ := loadContainerConfig(strings.NewReader(containerConfig))
+	r := &Runtime{baseSandboxConfig: sandbox, baseContainerConfig: container, runID: "run"}
+
+	spec := []byte(`# cospeck:template
+apiVersion: v1
+kind: Pod
+metadata:
+  name: templated
+spec:
+  containers:
+    - name: app
+      image: busybox:{{ randChoice "1.32" "1.33" }}
+      args: ["{{ .Index }}", "{{ .RunID }}", "{{ randString 8 }}"]
+      resources:
+        limits:
+          memory: {{ mul (add .Index 1) 16 }}Mi
+`)
+	if !IsTemplate(spec) {
+		t.Fatalf("Expected spec to be a template")
+	}
+
+	first, _ := ExpandPodSpec(spec, TemplateData{Index: 2, RunID: "run"})
+	again, _ := ExpandPodSpec(spec, TemplateData{Index: 2, RunID: "run"})
+	if string(first) != string(again) {
+		t.Errorf("Expected the same expansion for the same run and index")
+	}
+
+	set := &WorkloadSet{Workloads: []*Workload{{}}, schedule: []int{0}, template: spec}
+
+	w, err := r.workload(set, 2)
+	if err != nil {
+		t.Fatalf("Error expanding workload: %s", err)
+	}
+	c := w.Containers[0]
+	if c.Args[0] != "2" || c.Args[1] != "run" || len(c.Args[2]) != 8 {
+		t.Errorf("Expected index, run id and a random string in args found %v", c.Args)
+	}
+	if c.Linux.Resources.MemoryLimitInBytes != 48*1024*1024 {
+		t.Errorf("Expected a 48Mi memory limit found %d", c.Linux.Resources.MemoryLimitInBytes)
+	}
+	if c.Image.Image != "busybox:1.32" && c.Image.Image != "busybox:1.33" {
+		t.Errorf("Expected a busybox image found %s", c.Image.Image)
+	}
+
+	if _, err := ExpandPodSpec([]byte("{{ .Missing }}"), TemplateData{}); err == nil {
+		t.Errorf("Expected an error for an unknown variable")
+	}
+
+	// braces in a spec without the marker are left alone
+	plain := []byte(strings.Replace(string(spec), TemplateMarker+"\n", "", 1))
+	if IsTemplate(plain) {
+		t.Errorf("Expected a spec without %q not to be a template", TemplateMarker)
+	}
+}
+
+var mixedWorkloads = pod + `
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 3
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+        - name: nginx
+          image: docker.io/library/nginx:alpine
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: batch
+spec:
+  parallelism: 2
+  template:
+    spec:
+      initContainers:
+        - name: migrate
+          image: docker.io/library/busybox:latest
+          command: ["true"]
+        - name: warm-cache
+          image: docker.io/library/busybox:latest
+          command: ["sleep", "1"]
+      containers:
+        - name: work
+          image: docker.io/library/busybox:latest
+          command: ["sleep", "30"]
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: scaled-down
+spec:
+  replicas: 0
+  template:
+    spec:
+      containers:
+        - name: db
+          image: docker.io/library/busybox:latest
+`
+
+var fullPod = `apiVersion: v1
+kind: Pod
+metadata:
+  name: full-pod
+  labels:
+    app: web
+  annotations:
+    team: perf
+spec:
+  hostNetwork: true
+  securityContext:
+    runAsUser: 2000
+    runAsGroup: 3000
+  volumes:
+    - name: cache
+      emptyDir: {}
+    - name: logs
+      hostPath:
+        path: /var/log
+  containers:
+    - name: web
+      image: docker.io/library/busybox:latest
+      command: ["sh"]
+      args: ["-c", "sleep 5000"]
+      workingDir: /srv
+      env:
+        - name: MODE
+          value: bench
+        - name: TERM
+          value: dumb
+      resources:
+        requests:
+          cpu: 250m
+        limits:
+          cpu: 500m
+          memory: 64Mi
+      securityContext:
+        runAsUser: 1000
+        readOnlyRootFilesystem: true
+        allowPrivilegeEscalation: false
+      ports:
+        - containerPort: 80
+          hostPort: 8080
+      volumeMounts:
+        - name: cache
+          mountPath: /cache
+        - name: logs
+          mountPath: /logs
+          subPath: app
+          readOnly: true
+    - name: sidecar
+      image: docker.io/library/busybox:latest
+      command: ["sleep", "5000"]`
+
+var pod = `apiVersion: v1
 kind: Pod
 metadata:
   name: basic-pod
@@ -43,9 +387,9 @@ spec:
       ports:
         - name: web
           containerPort: 80
-          protocol: TCP`)
+          protocol: TCP`
 
-var containerConfig io.Reader = strings.NewReader(`{
+var containerConfig = `{
 	"metadata": {
 		"name": "cospeck",
 		"attempt": 1
@@ -98,9 +442,9 @@ var containerConfig io.Reader = strings.NewReader(`{
 			}
 		}
 	}
-}`)
+}`
 
-var sandboxConfig io.Reader = strings.NewReader(`{
+var sandboxConfig = `{
 	"metadata": {
 		"name": "cospeck",
 		"uid": "cospeck-test-cri",
@@ -149,4 +493,43 @@ var sandboxConfig io.Reader = strings.NewReader(`{
 			}
 		}
 	}
-}`)
+}`
+
+func TestRemoveEmptyDirsRefusesPaths(t *testing.T) {
+	for _, name := range []string{".", "..", "a/../../x", "/etc"} {
+		if err := removeEmptyDirs(name); err == nil {
+			t.Errorf("Expected pod name %q to be refused", name)
+		}
+	}
+	if err := removeEmptyDirs(""); err != nil {
+		t.Errorf("Expected no pod name to be a no-op found %v", err)
+	}
+}
+
+func TestInitContainerPortsNotMapped(t *testing.T) {
+	spec := `apiVersion: v1
+kind: Pod
+metadata:
+  name: ports
+spec:
+  initContainers:
+    - name: setup
+      image: busybox
+      ports:
+        - containerPort: 9000
+          hostPort: 9000
+  containers:
+    - name: web
+      image: nginx
+      ports:
+        - containerPort: 80
+          hostPort: 8080
+`
+	pod, _, err := ParseYamlFileWithPodConfig([]byte(spec), strings.NewReader(sandboxConfig), strings.NewReader(containerConfig))
+	if err != nil {
+		t.Fatalf("Error parsing YAML file: %s\n", err)
+	}
+	if len(pod.PortMappings) != 1 || pod.PortMappings[0].ContainerPort != 80 {
+		t.Errorf("Expected only the app containers port mapped found %v", pod.PortMappings)
+	}
+}
@@ -0,0 +1,219 @@
+package cri
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// SpecIssue is a field of a pod spec that will not make it into the cri configs. Unknown fields
+// are not kubernetes fields at all, usually a typo, the rest are valid but ignored by cospeck
+type SpecIssue struct {
+	Document int
+	Path     string
+	Unknown  bool
+}
+
+func (i SpecIssue) String() string {
+	if i.Unknown {
+		return fmt.Sprintf("document %d: unknown field %s", i.Document, i.Path)
+	}
+	return fmt.Sprintf("document %d: %s is not supported and is ignored", i.Document, i.Path)
+}
+
+// fieldSet is a tree of the fields translatePod uses, a "*" child accepts anything below it
+type fieldSet map[string]fieldSet
+
+func newFieldSet(paths ...string) fieldSet {
+	set := fieldSet{}
+	for _, p := range paths {
+		node := set
+		for _, part := range strings.Split(p, ".") {
+			child, ok := node[part]
+			if !ok {
+				child = fieldSet{}
+				node[part] = child
+			}
+			node = child
+		}
+	}
+	return set
+}
+
+// prefix returns paths with each one prefixed, e.g. the container fields under spec.containers
+func prefix(p string, paths []string) []string {
+	out := make([]string, 0, len(paths))
+	for _, path := range paths {
+		out = append(out, p+"."+path)
+	}
+	return out
+}
+
+var (
+	metadataFields = []string{"name", "namespace", "labels.*", "annotations.*"}
+
+	containerFields = []string{
+		"name", "image", "command", "args", "workingDir",
+		"env.name", "env.value",
+		"volumeMounts.name", "volumeMounts.mountPath", "volumeMounts.readOnly", "volumeMounts.subPath", "volumeMounts.mountPropagation",
+		"ports.name", "ports.containerPort", "ports.hostPort", "ports.hostIP", "ports.protocol",
+		"resources.requests.cpu", "resources.limits.cpu", "resources.limits.memory",
+		"securityContext.privileged", "securityContext.runAsUser", "securityContext.runAsGroup", "securityContext.readOnlyRootFilesystem",
+		"securityContext.allowPrivilegeEscalation", "securityContext.capabilities.add", "securityContext.capabilities.drop",
+		"securityContext.seLinuxOptions.*",
+	}
+
+	podSpecFields = append(append([]string{
+		"hostname", "hostNetwork", "hostPID", "hostIPC", "shareProcessNamespace",
+		"securityContext.runAsUser", "securityContext.runAsGroup", "securityContext.supplementalGroups", "securityContext.fsGroup",
+		"volumes.name", "volumes.hostPath.path", "volumes.emptyDir",
+	}, prefix("containers", containerFields)...), prefix("initContainers", containerFields)...)
+
+	podFields = newFieldSet(append(append([]string{"apiVersion", "kind"},
+		prefix("metadata", metadataFields)...), prefix("spec", podSpecFields)...)...)
+
+	templateFields = append(append([]string{"apiVersion", "kind", "metadata.name", "metadata.namespace"},
+		prefix("spec.template.metadata", metadataFields)...), prefix("spec.template.spec", podSpecFields)...)
+
+	replicatedFields = newFieldSet(append([]string{"spec.replicas"}, templateFields...)...)
+	jobFields        = newFieldSet(append([]string{"spec.parallelism"}, templateFields...)...)
+)
+
+// ValidatePodSpec checks every document of a pod spec file for fields that are misspelt or that cospeck ignores.
+// Documents that can't be parsed at all are an error
+func ValidatePodSpec(file []byte) ([]SpecIssue, error) {
+	reader := k8syaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(file)))
+
+	issues := []SpecIssue{}
+	for doc := 1; ; doc++ {
+		b, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(b)) == 0 {
+			continue
+		}
+
+		j, err := yaml.YAMLToJSON(b)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %v", doc, err)
+		}
+		var meta metav1.TypeMeta
+		if err := json.Unmarshal(j, &meta); err != nil {
+			return nil, fmt.Errorf("document %d: %v", doc, err)
+		}
+
+		var t reflect.Type
+		var supported fieldSet
+		switch meta.Kind {
+		case "Pod", "":
+			t, supported = reflect.TypeOf(v1.Pod{}), podFields
+		case "Deployment":
+			t, supported = reflect.TypeOf(appsv1.Deployment{}), replicatedFields
+		case "ReplicaSet":
+			t, supported = reflect.TypeOf(appsv1.ReplicaSet{}), replicatedFields
+		case "StatefulSet":
+			t, supported = reflect.TypeOf(appsv1.StatefulSet{}), replicatedFields
+		case "Job":
+			t, supported = reflect.TypeOf(batchv1.Job{}), jobFields
+		default:
+			return nil, fmt.Errorf("document %d: unsupported kind %q", doc, meta.Kind)
+		}
+
+		var fields interface{}
+		if err := json.Unmarshal(j, &fields); err != nil {
+			return nil, fmt.Errorf("document %d: %v", doc, err)
+		}
+		checkFields(doc, "", fields, t, supported, &issues)
+	}
+	return issues, nil
+}
+
+// checkFields walks a decoded document alongside its kubernetes type, recording fields
+// that are not in the type (unknown) or not in the supported set (ignored)
+func checkFields(doc int, path string, value interface{}, t reflect.Type, supported fieldSet, issues *[]SpecIssue) {
+	if _, all := supported["*"]; all {
+		return
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch v := value.(type) {
+	case []interface{}:
+		if t.Kind() != reflect.Slice {
+			return
+		}
+		for i, e := range v {
+			checkFields(doc, fmt.Sprintf("%s[%d]", path, i), e, t.Elem(), supported, issues)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			fieldPath := k
+			if path != "" {
+				fieldPath = path + "." + k
+			}
+
+			var ft reflect.Type
+			switch t.Kind() {
+			case reflect.Map:
+				ft = t.Elem()
+			case reflect.Struct:
+				ft = jsonField(t, k)
+			}
+			if ft == nil {
+				*issues = append(*issues, SpecIssue{Document: doc, Path: fieldPath, Unknown: true})
+				continue
+			}
+
+			child, ok := supported[k]
+			if !ok {
+				*issues = append(*issues, SpecIssue{Document: doc, Path: fieldPath})
+				continue
+			}
+			checkFields(doc, fieldPath, v[k], ft, child, issues)
+		}
+	}
+}
+
+// jsonField returns the type of a structs field by its json name, looking through inlined structs
+func jsonField(t reflect.Type, name string) reflect.Type {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")
+		if tag[0] == "-" {
+			continue
+		}
+		if tag[0] == "" && f.Anonymous {
+			if ft := jsonField(f.Type, name); ft != nil {
+				return ft
+			}
+			continue
+		}
+		if tag[0] == name {
+			return f.Type
+		}
+	}
+	return nil
+}
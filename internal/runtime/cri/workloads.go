@@ -0,0 +1,232 @@
+package cri
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	criapi "github.com/Klaven/cospeck/cri"
+	"github.com/ghodss/yaml"
+	"google.golang.org/protobuf/proto"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// Workload is one pod shape out of a spec file. Weight is how many pods of this shape
+// there are for every Weight-sum pods created, e.g. a deployments replicas
+type Workload struct {
+	Name       string
+	Kind       string
+	Weight     int
+	Sandbox    *criapi.PodSandboxConfig
+	Containers []*criapi.ContainerConfig
+	// InitContainers run to completion, in order, before Containers are started
+	InitContainers []*criapi.ContainerConfig
+}
+
+// WorkloadSet is a weighted mix of workloads, pods are handed out so any run of pods matches the mix as closely as it can
+type WorkloadSet struct {
+	Workloads []*Workload
+	schedule  []int
+	// template is the unexpanded spec when the file is a go template, every pod expands it again
+	template []byte
+}
+
+// ParseWorkloads parses every document of a yaml file. Pods, Deployments, ReplicaSets, StatefulSets and Jobs are supported,
+// controllers contribute their pod template weighted by replicas (parallelism for jobs)
+func ParseWorkloads(file []byte, baseSandbox *criapi.PodSandboxConfig, baseContainer *criapi.ContainerConfig) (*WorkloadSet, error) {
+	reader := k8syaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(file)))
+
+	set := &WorkloadSet{}
+	for doc := 1; ; doc++ {
+		b, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(b)) == 0 {
+			continue
+		}
+
+		w, err := parseWorkload(b)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %v", doc, err)
+		}
+		if w == nil {
+			continue
+		}
+		if w.Weight <= 0 {
+			// scaled to zero, nothing of this shape runs
+			continue
+		}
+		set.Workloads = append(set.Workloads, w.translate(baseSandbox, baseContainer))
+	}
+
+	if len(set.Workloads) == 0 {
+		return nil, fmt.Errorf("no workloads found")
+	}
+	set.schedule = smoothWeightedSchedule(set.Workloads)
+	return set, nil
+}
+
+// Pick returns the workload for the i-th pod
+func (s *WorkloadSet) Pick(i int) *Workload {
+	return s.Workloads[s.slot(i)]
+}
+
+// slot returns the index in Workloads of the i-th pods workload
+func (s *WorkloadSet) slot(i int) int {
+	if i < 0 {
+		i = -i
+	}
+	return s.schedule[i%len(s.schedule)]
+}
+
+// Clone returns a copy of the workloads sandbox, container and init container configs that can be changed for a single pod
+func (w *Workload) Clone() (*criapi.PodSandboxConfig, []*criapi.ContainerConfig, []*criapi.ContainerConfig) {
+	return proto.Clone(w.Sandbox).(*criapi.PodSandboxConfig), cloneContainers(w.Containers), cloneContainers(w.InitContainers)
+}
+
+func cloneContainers(configs []*criapi.ContainerConfig) []*criapi.ContainerConfig {
+	containers := make([]*criapi.ContainerConfig, 0, len(configs))
+	for _, c := range configs {
+		containers = append(containers, proto.Clone(c).(*criapi.ContainerConfig))
+	}
+	return containers
+}
+
+// parsedWorkload is a pod template before translation to cri configs
+type parsedWorkload struct {
+	Workload
+	pod *v1.Pod
+}
+
+func (p *parsedWorkload) translate(baseSandbox *criapi.PodSandboxConfig, baseContainer *criapi.ContainerConfig) *Workload {
+	w := p.Workload
+	w.Sandbox, w.Containers, w.InitContainers = translatePod(p.pod, baseSandbox, baseContainer)
+	return &w
+}
+
+func parseWorkload(doc []byte) (*parsedWorkload, error) {
+	var meta metav1.TypeMeta
+	if err := yaml.Unmarshal(doc, &meta); err != nil {
+		return nil, err
+	}
+
+	switch meta.Kind {
+	case "Pod", "":
+		var pod v1.Pod
+		if err := yaml.Unmarshal(doc, &pod); err != nil {
+			return nil, err
+		}
+		return workloadFromPod("Pod", &pod, 1), nil
+	case "Deployment":
+		var d appsv1.Deployment
+		if err := yaml.Unmarshal(doc, &d); err != nil {
+			return nil, err
+		}
+		return workloadFromTemplate(meta.Kind, d.ObjectMeta, d.Spec.Template, d.Spec.Replicas), nil
+	case "ReplicaSet":
+		var rs appsv1.ReplicaSet
+		if err := yaml.Unmarshal(doc, &rs); err != nil {
+			return nil, err
+		}
+		return workloadFromTemplate(meta.Kind, rs.ObjectMeta, rs.Spec.Template, rs.Spec.Replicas), nil
+	case "StatefulSet":
+		var ss appsv1.StatefulSet
+		if err := yaml.Unmarshal(doc, &ss); err != nil {
+			return nil, err
+		}
+		return workloadFromTemplate(meta.Kind, ss.ObjectMeta, ss.Spec.Template, ss.Spec.Replicas), nil
+	case "Job":
+		var job batchv1.Job
+		if err := yaml.Unmarshal(doc, &job); err != nil {
+			return nil, err
+		}
+		return workloadFromTemplate(meta.Kind, job.ObjectMeta, job.Spec.Template, job.Spec.Parallelism), nil
+	}
+	return nil, fmt.Errorf("unsupported kind %q, expected one of Pod, Deployment, ReplicaSet, StatefulSet or Job", meta.Kind)
+}
+
+func workloadFromPod(kind string, pod *v1.Pod, weight int) *parsedWorkload {
+	return &parsedWorkload{
+		Workload: Workload{Name: pod.Name, Kind: kind, Weight: weight},
+		pod:      pod,
+	}
+}
+
+// workloadFromTemplate builds a pod from a controllers template, nil replicas defaults to one like the api server
+func workloadFromTemplate(kind string, owner metav1.ObjectMeta, template v1.PodTemplateSpec, replicas *int32) *parsedWorkload {
+	weight := 1
+	if replicas != nil {
+		weight = int(*replicas)
+	}
+
+	pod := &v1.Pod{ObjectMeta: template.ObjectMeta, Spec: template.Spec}
+	if pod.Name == "" {
+		pod.Name = owner.Name
+	}
+	if pod.Namespace == "" {
+		pod.Namespace = owner.Namespace
+	}
+	return workloadFromPod(kind, pod, weight)
+}
+
+// smoothWeightedSchedule orders one full round of the workloads so each shape is spread evenly through it,
+// the same smooth weighted round robin nginx uses
+func smoothWeightedSchedule(workloads []*Workload) []int {
+	total := 0
+	for _, w := range workloads {
+		total += w.Weight
+	}
+
+	current := make([]int, len(workloads))
+	schedule := make([]int, 0, total)
+	for len(schedule) < total {
+		best := 0
+		for i, w := range workloads {
+			current[i] += w.Weight
+			if current[i] > current[best] {
+				best = i
+			}
+		}
+		current[best] -= total
+		schedule = append(schedule, best)
+	}
+	return schedule
+}
+
+// Images returns every image a run of the set pulls, the pause image included, in the order they are first used
+func (s *WorkloadSet) Images() []string {
+	seen := map[string]bool{}
+	images := []string{}
+	add := func(image string) {
+		if !seen[image] {
+			seen[image] = true
+			images = append(images, image)
+		}
+	}
+	add(defaultPauseImage)
+	for _, w := range s.Workloads {
+		for _, c := range append(w.InitContainers, w.Containers...) {
+			add(c.Image.Image)
+		}
+	}
+	return images
+}
+
+// String describes the mix, e.g. "web (Deployment) x3, batch (Job) x1"
+func (s *WorkloadSet) String() string {
+	parts := make([]string, 0, len(s.Workloads))
+	for _, w := range s.Workloads {
+		parts = append(parts, fmt.Sprintf("%s (%s) x%d", w.Name, w.Kind, w.Weight))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -1,9 +1,12 @@
 package runtime
 
+import "time"
+
 type Pod interface {
 	Name() string
 	PodID() string
 	Containers() []Container
 	AddContainer(container Container)
 	GetContainer(name string) Container
+	InitTime() time.Duration
 }
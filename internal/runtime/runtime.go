@@ -15,4 +15,5 @@ type Runtime interface {
 	ProcNames() []string
 	CreatePodAndContainerFromSpec(ctx context.Context, fileName, uid string) (Pod, error)
 	Run(ctx context.Context, ctr Container) (time.Duration, error)
+	WaitRunning(ctx context.Context, ctr Container) (time.Duration, error)
 }
@@ -0,0 +1,224 @@
+package scenario
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+// Phase types a scenario can be built from
+const (
+	Create = "create"
+	Wait   = "wait"
+	Churn  = "churn"
+	Exec   = "exec"
+	Stop   = "stop"
+	Remove = "remove"
+	Sample = "sample"
+)
+
+// Scenario is a declarative, multi-phase benchmark definition
+type Scenario struct {
+	Name string `json:"name"`
+	// Runtime and CGroupPath override the cmd line flags when set
+	Runtime    string `json:"runtime,omitempty"`
+	CGroupPath string `json:"cgroupPath,omitempty"`
+	// Pod is the default pod spec for phases that don't set one
+	Pod            string      `json:"pod,omitempty"`
+	Concurrency    int         `json:"concurrency,omitempty"`
+	SampleInterval Duration    `json:"sampleInterval,omitempty"`
+	Phases         []Phase     `json:"phases"`
+	Assertions     []Assertion `json:"assertions,omitempty"`
+}
+
+// Phase is a single step of a scenario
+type Phase struct {
+	Name        string   `json:"name,omitempty"`
+	Type        string   `json:"type"`
+	Pod         string   `json:"pod,omitempty"`
+	Count       int      `json:"count,omitempty"`
+	Concurrency int      `json:"concurrency,omitempty"`
+	Duration    Duration `json:"duration,omitempty"`
+	// Rate is pods replaced per second for churn phases
+	Rate    float64  `json:"rate,omitempty"`
+	Command []string `json:"command,omitempty"`
+}
+
+// Assertion fails the scenario if a metric is over its max
+type Assertion struct {
+	Metric string `json:"metric"`
+	// Max is a duration for latency metrics, e.g. "5s", and a number otherwise
+	Max Limit `json:"max"`
+}
+
+// Limit is an assertions max, written as either a string or a number
+type Limit string
+
+// UnmarshalJSON accepts both 500 and "500"
+func (l *Limit) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*l = Limit(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return fmt.Errorf("max must be a number or a duration: %v", err)
+	}
+	*l = Limit(n.String())
+	return nil
+}
+
+// Duration is a time.Duration written as a string such as "10s" in scenario files
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("durations must be strings such as \"10s\": %v", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Metrics an assertion can check
+var metrics = map[string]bool{
+	"create.p50":     true,
+	"create.p90":     true,
+	"create.p99":     true,
+	"create.max":     true,
+	"stop.p99":       true,
+	"exec.p50":       true,
+	"exec.p99":       true,
+	"runtime.memory": false,
+	"runtime.cpu":    false,
+	"failures":       false,
+}
+
+// IsLatency returns true if the metric is a duration
+func IsLatency(metric string) bool {
+	return metrics[metric]
+}
+
+// Limit returns the assertions max in the units of the metric, seconds for latencies
+func (a Assertion) Limit() (float64, error) {
+	if IsLatency(a.Metric) {
+		d, err := time.ParseDuration(string(a.Max))
+		if err != nil {
+			return 0, err
+		}
+		return d.Seconds(), nil
+	}
+	return strconv.ParseFloat(string(a.Max), 64)
+}
+
+// Load reads a scenario file, pod paths in it are relative to the file
+func Load(path string) (*Scenario, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	s.Pod = resolve(dir, s.Pod)
+	for i := range s.Phases {
+		s.Phases[i].Pod = resolve(dir, s.Phases[i].Pod)
+	}
+	return s, nil
+}
+
+// Parse parses and validates a scenario, filling in defaults
+func Parse(b []byte) (*Scenario, error) {
+	s := &Scenario{}
+	if err := yaml.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+
+	if len(s.Phases) == 0 {
+		return nil, fmt.Errorf("scenario has no phases")
+	}
+	if s.Concurrency <= 0 {
+		s.Concurrency = 5
+	}
+
+	for i := range s.Phases {
+		p := &s.Phases[i]
+		if p.Name == "" {
+			p.Name = fmt.Sprintf("%d-%s", i, p.Type)
+		}
+		if p.Concurrency <= 0 {
+			p.Concurrency = s.Concurrency
+		}
+		if p.Pod == "" {
+			p.Pod = s.Pod
+		}
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("phase %q: %v", p.Name, err)
+		}
+	}
+
+	for _, a := range s.Assertions {
+		if _, ok := metrics[a.Metric]; !ok {
+			return nil, fmt.Errorf("unknown assertion metric %q", a.Metric)
+		}
+		if _, err := a.Limit(); err != nil {
+			return nil, fmt.Errorf("assertion %q: bad max %q: %v", a.Metric, a.Max, err)
+		}
+	}
+	return s, nil
+}
+
+func (p *Phase) validate() error {
+	switch p.Type {
+	case Create:
+		if p.Count <= 0 {
+			return fmt.Errorf("create needs a count")
+		}
+	case Wait:
+		if p.Duration.Duration <= 0 {
+			return fmt.Errorf("wait needs a duration")
+		}
+	case Churn:
+		if p.Duration.Duration <= 0 || p.Rate <= 0 {
+			return fmt.Errorf("churn needs a duration and a rate")
+		}
+	case Exec:
+		if p.Duration.Duration <= 0 || len(p.Command) == 0 {
+			return fmt.Errorf("exec needs a duration and a command")
+		}
+	case Stop, Remove, Sample:
+	default:
+		return fmt.Errorf("unknown phase type %q, expected one of %s", p.Type,
+			strings.Join([]string{Create, Wait, Churn, Exec, Stop, Remove, Sample}, ", "))
+	}
+	return nil
+}
+
+func resolve(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
@@ -0,0 +1,63 @@
+package scenario
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadScenario(t *testing.T) {
+	s, err := Load("../../config/scenario.yaml")
+	if err != nil {
+		t.Fatalf("Error loading scenario: %s", err)
+	}
+
+	if len(s.Phases) != 9 {
+		t.Errorf("Expected 9 phases found %d", len(s.Phases))
+	}
+
+	churn := s.Phases[4]
+	if churn.Type != Churn || churn.Rate != 2 || churn.Duration.Duration != time.Minute {
+		t.Errorf("Expected a churn phase at 2/s for 1m found %+v", churn)
+	}
+
+	if churn.Name != "4-churn" {
+		t.Errorf("Expected default phase name '4-churn' found '%s'", churn.Name)
+	}
+
+	if churn.Concurrency != 5 {
+		t.Errorf("Expected phase to inherit concurrency 5 found %d", churn.Concurrency)
+	}
+
+	if want := filepath.Join("../../config", "pod.yaml"); churn.Pod != want {
+		t.Errorf("Expected pod '%s' found '%s'", want, churn.Pod)
+	}
+
+	limit, err := s.Assertions[0].Limit()
+	if err != nil || limit != 5 {
+		t.Errorf("Expected create.p99 limit of 5 seconds found %v (%v)", limit, err)
+	}
+
+	limit, err = s.Assertions[2].Limit()
+	if err != nil || limit != 500 {
+		t.Errorf("Expected runtime.memory limit of 500 found %v (%v)", limit, err)
+	}
+}
+
+func TestParseScenarioErrors(t *testing.T) {
+	bad := map[string]string{
+		"no phases":      `name: empty`,
+		"unknown phase":  "phases:\n  - type: explode",
+		"create count":   "phases:\n  - type: create",
+		"churn rate":     "phases:\n  - type: churn\n    duration: 10s",
+		"bad duration":   "phases:\n  - type: wait\n    duration: 10",
+		"unknown metric": "phases:\n  - type: stop\nassertions:\n  - metric: nope\n    max: 1",
+		"bad limit":      "phases:\n  - type: stop\nassertions:\n  - metric: create.p99\n    max: 1",
+	}
+
+	for name, scenario := range bad {
+		if _, err := Parse([]byte(scenario)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
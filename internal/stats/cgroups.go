@@ -3,6 +3,7 @@ package stats
 import (
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/containerd/cgroups"
@@ -14,7 +15,9 @@ import (
 
 // CGroupsSampler represents Linux cgroups sampler
 type CGroupsSampler struct {
-	control      cgroups.Cgroup
+	control cgroups.Cgroup
+	// mu serialises samples, each one's cpu usage is since the one before
+	mu           sync.Mutex
 	lastCPUUsage uint64
 	lastCPUTime  time.Time
 }
@@ -130,6 +133,8 @@ func (s *CGroupSamplerV2) Sample(name string) (*Metrics, error) {
 
 // Sample gets a process metrics from control cgroup
 func (s *CGroupsSampler) Sample(name string) (*Metrics, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	metrics, err := s.control.Stat(cgroups.IgnoreNotExist)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get metrics from cgroup")
@@ -158,6 +163,28 @@ func (s *CGroupsSampler) Sample(name string) (*Metrics, error) {
 	}, nil
 }
 
+// Memory returns the current RSS of the cgroup in MiB without touching the CPU sampling state
+func (s *CGroupsSampler) Memory() (uint64, error) {
+	metrics, err := s.control.Stat(cgroups.IgnoreNotExist)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get metrics from cgroup")
+	}
+	return metrics.Memory.TotalRSS / bytesInMiB, nil
+}
+
+// Pids returns the processes in the cgroup and its children
+func (s *CGroupsSampler) Pids() ([]int, error) {
+	procs, err := s.control.Processes(cgroups.Memory, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list cgroup processes")
+	}
+	pids := make([]int, 0, len(procs))
+	for _, p := range procs {
+		pids = append(pids, p.Pid)
+	}
+	return pids, nil
+}
+
 // Stat gets the stats
 func (s *CGroupsSampler) Stat() (*v1.Metrics, error) {
 	return s.control.Stat()
@@ -0,0 +1,97 @@
+package stats
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CRILog is what was read from a CRI container log file, lines are counted once they are complete
+// (a full line or the last of its partials). Lines of the logs workload start with a sequence number,
+// gaps in it are lines the runtime dropped
+type CRILog struct {
+	Lines int
+	Bytes int64
+	// Partial is how many records were partial lines, the runtime splits long lines into several
+	Partial int
+	// Malformed is how many records didn't parse as a CRI log line
+	Malformed int
+	// Dropped is how many sequence numbers are missing between the first and last line seen
+	Dropped int
+	// Times is when each complete line was logged
+	Times []time.Time
+}
+
+// ReadCRILog parses a CRI log file, each record is "<rfc3339nano> <stream> <P|F> <message>"
+func ReadCRILog(path string) (*CRILog, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseCRILog(f)
+}
+
+// ParseCRILog parses CRI log records from r, see ReadCRILog
+func ParseCRILog(r io.Reader) (*CRILog, error) {
+	log := &CRILog{}
+	seen := map[int64]bool{}
+	first, last := int64(-1), int64(-1)
+	var line strings.Builder
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 4)
+		if len(fields) < 3 {
+			log.Malformed++
+			continue
+		}
+		ts, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			log.Malformed++
+			continue
+		}
+		message := ""
+		if len(fields) == 4 {
+			message = fields[3]
+		}
+		line.WriteString(message)
+
+		switch fields[2] {
+		case "P":
+			log.Partial++
+			continue
+		case "F":
+		default:
+			log.Malformed++
+			line.Reset()
+			continue
+		}
+
+		full := line.String()
+		line.Reset()
+		log.Lines++
+		log.Bytes += int64(len(full))
+		log.Times = append(log.Times, ts)
+
+		seq, err := strconv.ParseInt(strings.SplitN(full, " ", 2)[0], 10, 64)
+		if err != nil || seq < 0 {
+			continue
+		}
+		seen[seq] = true
+		if first < 0 || seq < first {
+			first = seq
+		}
+		if seq > last {
+			last = seq
+		}
+	}
+	if first >= 0 {
+		log.Dropped = int(last-first+1) - len(seen)
+	}
+	return log, scanner.Err()
+}
@@ -0,0 +1,31 @@
+package stats
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCRILog(t *testing.T) {
+	records := []string{
+		"2020-10-06T00:17:09.669794202Z stdout F 0 xxxx",
+		"2020-10-06T00:17:09.679794202Z stdout P 1 xx",
+		"2020-10-06T00:17:09.679794202Z stdout F xx",
+		"not a log line",
+		"2020-10-06T00:17:09.699794202Z stdout F 4 xxxx",
+		"2020-10-06T00:17:09.709794202Z stderr F",
+	}
+
+	log, err := ParseCRILog(strings.NewReader(strings.Join(records, "\n")))
+	if err != nil {
+		t.Fatalf("Error parsing log: %s", err)
+	}
+	if log.Lines != 4 || log.Partial != 1 || log.Malformed != 1 {
+		t.Errorf("Expected 4 lines, 1 partial and 1 malformed found %d, %d and %d", log.Lines, log.Partial, log.Malformed)
+	}
+	if log.Bytes != 18 {
+		t.Errorf("Expected the partial line to be joined to 6 bytes, 18 in total found %d", log.Bytes)
+	}
+	if log.Dropped != 2 {
+		t.Errorf("Expected lines 2 and 3 to be dropped found %d", log.Dropped)
+	}
+}
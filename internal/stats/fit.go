@@ -0,0 +1,28 @@
+package stats
+
+// Fit returns the least squares line through the points, y = intercept + slope*x.
+// Fewer than two distinct x values give a zero slope and the mean of y
+func Fit(xs, ys []float64) (slope, intercept float64) {
+	n := float64(len(xs))
+	if len(xs) == 0 || len(xs) != len(ys) {
+		return 0, 0
+	}
+
+	var sumX, sumY float64
+	for i := range xs {
+		sumX += xs[i]
+		sumY += ys[i]
+	}
+	meanX, meanY := sumX/n, sumY/n
+
+	var sxy, sxx float64
+	for i := range xs {
+		sxy += (xs[i] - meanX) * (ys[i] - meanY)
+		sxx += (xs[i] - meanX) * (xs[i] - meanX)
+	}
+	if sxx == 0 {
+		return 0, meanY
+	}
+	slope = sxy / sxx
+	return slope, meanY - slope*meanX
+}
@@ -0,0 +1,28 @@
+package stats
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		name             string
+		xs, ys           []float64
+		slope, intercept float64
+	}{
+		{"empty", nil, nil, 0, 0},
+		{"mismatched", []float64{1, 2}, []float64{1}, 0, 0},
+		{"single point", []float64{3}, []float64{7}, 0, 7},
+		{"flat x", []float64{2, 2, 2}, []float64{1, 2, 3}, 0, 2},
+		{"line", []float64{0, 1, 2, 3}, []float64{1, 3, 5, 7}, 2, 1},
+		{"falling", []float64{1, 2, 3}, []float64{10, 8, 6}, -2, 12},
+		{"noisy", []float64{0, 1, 2, 3}, []float64{0, 2, 2, 4}, 1.2, 0.2},
+	}
+	for _, tt := range tests {
+		slope, intercept := Fit(tt.xs, tt.ys)
+		if math.Abs(slope-tt.slope) > 1e-9 || math.Abs(intercept-tt.intercept) > 1e-9 {
+			t.Errorf("%s: expected slope %v intercept %v found %v %v", tt.name, tt.slope, tt.intercept, slope, intercept)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package stats
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	goruntime "runtime"
+	"strconv"
+	"strings"
+)
+
+// HostInfo describes the machine a test was run on
+type HostInfo struct {
+	Hostname      string `json:"hostname"`
+	KernelVersion string `json:"kernelVersion"`
+	CGroupVersion string `json:"cgroupVersion"`
+	CPUModel      string `json:"cpuModel"`
+	CPUCount      int    `json:"cpuCount"`
+	MemTotal      uint64 `json:"memTotal"`
+}
+
+// Host gathers information about the host we are running on, anything we can't read is left empty
+func Host() *HostInfo {
+	info := &HostInfo{
+		CPUCount:      goruntime.NumCPU(),
+		CGroupVersion: CGroupVersion(),
+	}
+
+	info.Hostname, _ = os.Hostname()
+
+	if release, err := ioutil.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		info.KernelVersion = strings.TrimSpace(string(release))
+	}
+
+	info.CPUModel = procField("/proc/cpuinfo", "model name")
+
+	if total := procField("/proc/meminfo", "MemTotal"); total != "" {
+		info.MemTotal = parseKiB(total) / bytesInMiB
+	}
+
+	return info
+}
+
+// NodeMemory returns how much of the nodes memory is in use and how much is available in MiB, from /proc/meminfo
+func NodeMemory() (used, available uint64) {
+	total := parseKiB(procField("/proc/meminfo", "MemTotal")) / bytesInMiB
+	available = parseKiB(procField("/proc/meminfo", "MemAvailable")) / bytesInMiB
+	if available > total {
+		return 0, available
+	}
+	return total - available, available
+}
+
+// MemoryPressure returns the "some" memory pressure stall average over the last 10 seconds as a percent,
+// it errors on kernels without PSI
+func MemoryPressure() (float64, error) {
+	b, err := ioutil.ReadFile("/proc/pressure/memory")
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "some" {
+			continue
+		}
+		for _, f := range fields[1:] {
+			if strings.HasPrefix(f, "avg10=") {
+				return strconv.ParseFloat(strings.TrimPrefix(f, "avg10="), 64)
+			}
+		}
+	}
+	return 0, fmt.Errorf("no memory pressure average in /proc/pressure/memory")
+}
+
+// CGroupVersion returns "v2" on a unified hierarchy and "v1" otherwise
+func CGroupVersion() string {
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err == nil {
+		return "v2"
+	}
+	return "v1"
+}
+
+// procField returns the value of the first "key: value" line in a /proc style file
+func procField(path, key string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == key {
+			return strings.TrimSpace(parts[1])
+		}
+	}
+	return ""
+}
+
+// parseKiB parses a "1234 kB" value into bytes
+func parseKiB(value string) uint64 {
+	n, err := strconv.ParseUint(strings.TrimSpace(strings.TrimSuffix(value, "kB")), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n * 1024
+}
@@ -0,0 +1,48 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	ten := []time.Duration{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}
+	tests := []struct {
+		name      string
+		durations []time.Duration
+		p         float64
+		want      time.Duration
+	}{
+		{"empty", nil, 50, 0},
+		{"single", []time.Duration{7}, 99, 7},
+		{"p0", ten, 0, 1},
+		{"p10", ten, 10, 1},
+		{"p11", ten, 11, 2},
+		{"p50", ten, 50, 5},
+		{"p51", ten, 51, 6},
+		{"p90", ten, 90, 9},
+		{"p91", ten, 91, 10},
+		{"p99", ten, 99, 10},
+		{"p100", ten, 100, 10},
+		{"p50 of two", []time.Duration{2, 1}, 50, 1},
+		{"p99 of a hundred", hundred(), 99, 99},
+		{"p7 of a hundred", hundred(), 7, 7},
+	}
+	for _, tt := range tests {
+		if got := Percentile(tt.durations, tt.p); got != tt.want {
+			t.Errorf("%s: expected %d found %d", tt.name, tt.want, got)
+		}
+	}
+
+	if ten[0] != 10 {
+		t.Errorf("Expected the durations to be left unsorted")
+	}
+}
+
+func hundred() []time.Duration {
+	d := []time.Duration{}
+	for i := 100; i > 0; i-- {
+		d = append(d, time.Duration(i))
+	}
+	return d
+}
@@ -0,0 +1,144 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// errFound stops a walk once the cgroup is found
+var errFound = errors.New("found")
+
+// ContainerCGroup finds a containers cgroup by its id and returns its path relative to the hierarchy root.
+// Runtimes name it after the id (<id>, cri-containerd-<id>.scope, crio-<id>.scope), cri-o's conmon scope is skipped
+func ContainerCGroup(id string) (string, error) {
+	if id == "" {
+		return "", fmt.Errorf("no container id")
+	}
+	root := cgroupRoot
+	if CGroupVersion() == "v1" {
+		root = filepath.Join(cgroupRoot, "memory")
+	}
+
+	found := ""
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// cgroups come and go while we walk
+			return nil
+		}
+		name := info.Name()
+		if info.IsDir() && strings.Contains(name, id) && !strings.Contains(name, "conmon") {
+			found = path
+			return errFound
+		}
+		return nil
+	})
+	if err != nil && err != errFound {
+		return "", err
+	}
+	if found == "" {
+		return "", fmt.Errorf("no cgroup found for container %s", id)
+	}
+	return filepath.Rel(root, found)
+}
+
+// CGroupLimits are the cpu and memory limits set on a cgroup, -1 is unlimited
+type CGroupLimits struct {
+	Memory    int64 `json:"memory"`
+	CPUQuota  int64 `json:"cpuQuota"`
+	CPUPeriod int64 `json:"cpuPeriod"`
+}
+
+// ReadCGroupLimits reads the limits of a cgroup given relative to the hierarchy root, memory.max and cpu.max on v2,
+// memory.limit_in_bytes and cpu.cfs_quota_us and cpu.cfs_period_us on v1
+func ReadCGroupLimits(cgroup string) (CGroupLimits, error) {
+	var limits CGroupLimits
+	if CGroupVersion() == "v2" {
+		dir := filepath.Join(cgroupRoot, cgroup)
+		memory, err := readCGroupFile(filepath.Join(dir, "memory.max"))
+		if err != nil {
+			return limits, err
+		}
+		if limits.Memory, err = parseLimit(memory); err != nil {
+			return limits, err
+		}
+		cpu, err := readCGroupFile(filepath.Join(dir, "cpu.max"))
+		if err != nil {
+			return limits, err
+		}
+		fields := strings.Fields(cpu)
+		if len(fields) != 2 {
+			return limits, fmt.Errorf("unexpected cpu.max %q", cpu)
+		}
+		if limits.CPUQuota, err = parseLimit(fields[0]); err != nil {
+			return limits, err
+		}
+		limits.CPUPeriod, err = parseLimit(fields[1])
+		return limits, err
+	}
+
+	memory, err := readCGroupFile(filepath.Join(cgroupRoot, "memory", cgroup, "memory.limit_in_bytes"))
+	if err != nil {
+		return limits, err
+	}
+	if limits.Memory, err = parseLimit(memory); err != nil {
+		return limits, err
+	}
+	// the cpu controller is mounted on its own or together with cpuacct depending on the distribution
+	cpuDir := filepath.Join(cgroupRoot, "cpu,cpuacct", cgroup)
+	if _, err := os.Stat(cpuDir); err != nil {
+		cpuDir = filepath.Join(cgroupRoot, "cpu", cgroup)
+	}
+	for file, value := range map[string]*int64{"cpu.cfs_quota_us": &limits.CPUQuota, "cpu.cfs_period_us": &limits.CPUPeriod} {
+		s, err := readCGroupFile(filepath.Join(cpuDir, file))
+		if err != nil {
+			return limits, err
+		}
+		if *value, err = parseLimit(s); err != nil {
+			return limits, err
+		}
+	}
+	return limits, nil
+}
+
+func readCGroupFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// parseLimit parses a cgroup limit, "max" on v2 is unlimited
+func parseLimit(s string) (int64, error) {
+	if s == "max" {
+		return -1, nil
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// OOMKills returns how many processes the kernel OOM killer has killed in a cgroup given relative to the hierarchy root,
+// from memory.events on v2 and memory.oom_control on v1
+func OOMKills(cgroup string) (int, error) {
+	path := filepath.Join(cgroupRoot, cgroup, "memory.events")
+	if CGroupVersion() == "v1" {
+		path = filepath.Join(cgroupRoot, "memory", cgroup, "memory.oom_control")
+	}
+	events, err := readCGroupFile(path)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(events, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			return strconv.Atoi(fields[1])
+		}
+	}
+	return 0, fmt.Errorf("no oom_kill count in %s", path)
+}
@@ -0,0 +1,177 @@
+package stats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProcessStats is the summed resource usage of a set of processes
+type ProcessStats struct {
+	FDs     int
+	Threads int
+	// RSS is in bytes
+	RSS uint64
+}
+
+// Processes sums the open fds, threads and RSS of the pids, processes that have exited are skipped
+func Processes(pids []int) ProcessStats {
+	var s ProcessStats
+	for _, pid := range pids {
+		fds, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/fd", pid))
+		if err != nil {
+			continue
+		}
+		s.FDs += len(fds)
+		status := fmt.Sprintf("/proc/%d/status", pid)
+		threads, _ := strconv.Atoi(procField(status, "Threads"))
+		s.Threads += threads
+		s.RSS += parseKiB(procField(status, "VmRSS"))
+	}
+	return s
+}
+
+// Mounts returns the number of entries in the mount table a process sees
+func Mounts(pid int) (int, error) {
+	return countLines(fmt.Sprintf("/proc/%d/mountinfo", pid))
+}
+
+// NetNamespaces returns how many distinct network namespaces the pids are in other than the hosts,
+// and how many are pinned under /run/netns
+func NetNamespaces(pids []int) (inUse, pinned int) {
+	host, _ := os.Readlink("/proc/1/ns/net")
+	namespaces := map[string]bool{}
+	for _, pid := range pids {
+		if ns, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/net", pid)); err == nil && ns != host {
+			namespaces[ns] = true
+		}
+	}
+	pinned, _ = DirEntries("/run/netns")
+	return len(namespaces), pinned
+}
+
+// CGroupTree returns how many cgroups are below parent, given relative to the hierarchy root (on v1 the memory one),
+// and the processes in them
+func CGroupTree(parent string) (int, []int, error) {
+	root := cgroupRoot
+	if CGroupVersion() == "v1" {
+		root = filepath.Join(cgroupRoot, "memory")
+	}
+	dir := filepath.Join(root, parent)
+	if _, err := os.Stat(dir); err != nil {
+		return 0, nil, err
+	}
+
+	count := 0
+	pids := []int{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// cgroups come and go while we walk
+			return nil
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != dir {
+			count++
+		}
+		procs, err := ioutil.ReadFile(filepath.Join(path, "cgroup.procs"))
+		if err != nil {
+			return nil
+		}
+		for _, line := range strings.Fields(string(procs)) {
+			if pid, err := strconv.Atoi(line); err == nil {
+				pids = append(pids, pid)
+			}
+		}
+		return nil
+	})
+	return count, pids, err
+}
+
+// DirEntries returns the number of entries in a directory
+func DirEntries(dir string) (int, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// ProcessCPU returns the cpu time used so far by each process whose name starts with one of names
+func ProcessCPU(names []string) map[int]time.Duration {
+	cpu := map[int]time.Duration{}
+	if len(names) == 0 {
+		return cpu
+	}
+	dirs, _ := ioutil.ReadDir("/proc")
+	for _, d := range dirs {
+		pid, err := strconv.Atoi(d.Name())
+		if err != nil {
+			continue
+		}
+		comm, err := ioutil.ReadFile("/proc/" + d.Name() + "/comm")
+		if err != nil {
+			continue
+		}
+		matched := false
+		for _, name := range names {
+			if strings.HasPrefix(strings.TrimSpace(string(comm)), name) {
+				matched = true
+			}
+		}
+		if !matched {
+			continue
+		}
+		if t, err := processCPU(pid); err == nil {
+			cpu[pid] = t
+		}
+	}
+	return cpu
+}
+
+// clockTicks is USER_HZ, which the kernel fixes at 100 for /proc
+const clockTicks = 100
+
+// processCPU reads a processes user and system time from /proc/<pid>/stat
+func processCPU(pid int) (time.Duration, error) {
+	stat, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0, err
+	}
+	// the name is in parentheses and can contain spaces, the fields after it are fixed
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	if len(fields) < 13 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(utime+stime) * time.Second / clockTicks, nil
+}
+
+func countLines(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines++
+	}
+	return lines, scanner.Err()
+}
@@ -0,0 +1,118 @@
+// Package streaming is a minimal client for the exec, attach and port-forward streams the CRI streaming server serves
+// behind the urls Exec, Attach and PortForward return. It speaks the kubernetes channel protocol over websocket:
+// every binary frame starts with the number of the channel it belongs to
+package streaming
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Protocol is the websocket subprotocol asked for, binary frames with a channel byte and a status on the error channel
+const Protocol = "v4.channel.k8s.io"
+
+// channels of exec and attach streams, port-forward streams have a data and an error channel per port
+const (
+	Stdin  byte = 0
+	Stdout byte = 1
+	Stderr byte = 2
+	Error  byte = 3
+)
+
+// Conn is a websocket stream to the runtimes streaming server
+type Conn struct {
+	ws *websocket.Conn
+}
+
+// Dial connects to a streaming url, http(s) urls are turned into ws(s) ones. Extra query parameters,
+// such as the ports of a port-forward, are added to the url
+func Dial(streamURL string, query url.Values, timeout time.Duration) (*Conn, error) {
+	u, err := url.Parse(streamURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing streaming url: %v", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("streaming url %q has no host, the runtimes stream server address is needed", streamURL)
+	}
+	switch u.Scheme {
+	case "http", "ws":
+		u.Scheme = "ws"
+	case "https", "wss":
+		u.Scheme = "wss"
+	default:
+		return nil, fmt.Errorf("unexpected streaming url scheme %q", u.Scheme)
+	}
+	q := u.Query()
+	for k, v := range query {
+		q[k] = append(q[k], v...)
+	}
+	u.RawQuery = q.Encode()
+
+	origin := "http://" + u.Host
+	config, err := websocket.NewConfig(u.String(), origin)
+	if err != nil {
+		return nil, err
+	}
+	config.Protocol = []string{Protocol}
+	config.Dialer = &net.Dialer{Timeout: timeout}
+
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{ws: ws}, nil
+}
+
+// SetDeadline sets the deadline for every read and write on the stream
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.ws.SetDeadline(t)
+}
+
+// Read returns the next frame with data and the channel it is on. The frames the server sends to
+// say a channel is ready carry no data and are skipped. It returns io.EOF once the server closes the stream
+func (c *Conn) Read() (byte, []byte, error) {
+	for {
+		var frame []byte
+		if err := websocket.Message.Receive(c.ws, &frame); err != nil {
+			return 0, nil, err
+		}
+		if len(frame) > 1 {
+			return frame[0], frame[1:], nil
+		}
+	}
+}
+
+// Write sends data on a channel
+func (c *Conn) Write(channel byte, data []byte) error {
+	frame := make([]byte, 0, len(data)+1)
+	frame = append(frame, channel)
+	frame = append(frame, data...)
+	return websocket.Message.Send(c.ws, frame)
+}
+
+// Close closes the stream
+func (c *Conn) Close() error {
+	return c.ws.Close()
+}
+
+// StatusError turns what the server sent on the error channel into an error, nil if the command succeeded.
+// The v4 protocol sends a kubernetes Status, only whether it failed and its message are looked at
+func StatusError(status []byte) error {
+	s := string(status)
+	if s == "" || strings.Contains(s, `"status":"Success"`) {
+		return nil
+	}
+	if i := strings.Index(s, `"message":"`); i >= 0 {
+		msg := s[i+len(`"message":"`):]
+		if j := strings.Index(msg, `"`); j >= 0 {
+			msg = msg[:j]
+		}
+		return fmt.Errorf("stream failed: %s", msg)
+	}
+	return fmt.Errorf("stream failed: %s", s)
+}
@@ -0,0 +1,50 @@
+package streaming
+
+import (
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestConn(t *testing.T) {
+	server := httptest.NewServer(websocket.Server{Handler: func(ws *websocket.Conn) {
+		if ws.Request().URL.Query().Get("port") != "80" {
+			return
+		}
+		// ready frames, then data and the status like the stream server sends for an exec
+		for _, frame := range [][]byte{{Stdout}, {Stderr}, {Error}, append([]byte{Stdout}, "hi"...), append([]byte{Error}, `{"status":"Success"}`...)} {
+			websocket.Message.Send(ws, frame)
+		}
+		ws.Close()
+	}})
+	defer server.Close()
+
+	conn, err := Dial(server.URL+"/exec/token", url.Values{"port": {"80"}}, time.Second)
+	if err != nil {
+		t.Fatalf("Error dialing: %s", err)
+	}
+	defer conn.Close()
+
+	channel, data, err := conn.Read()
+	if err != nil || channel != Stdout || string(data) != "hi" {
+		t.Errorf("Expected hi on stdout after the ready frames found %q on %d %v", data, channel, err)
+	}
+	channel, data, err = conn.Read()
+	if err != nil || channel != Error || StatusError(data) != nil {
+		t.Errorf("Expected a success status found %q on %d %v", data, channel, err)
+	}
+	if _, _, err := conn.Read(); err != io.EOF {
+		t.Errorf("Expected EOF once the server closed found %v", err)
+	}
+
+	if err := StatusError([]byte(`{"status":"Failure","message":"command not found"}`)); err == nil || err.Error() != "stream failed: command not found" {
+		t.Errorf("Expected the failure message found %v", err)
+	}
+	if _, err := Dial("/exec/token", nil, time.Second); err == nil {
+		t.Errorf("Expected an error for a url without a host")
+	}
+}
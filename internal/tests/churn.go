@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Klaven/cospeck/internal/logging"
+	"github.com/Klaven/cospeck/internal/runtime/cri"
+	"github.com/Klaven/cospeck/internal/stats"
+	"github.com/jedib0t/go-pretty/table"
+	"github.com/tidwall/limiter"
+)
+
+// churnResult is what a churn loop measured
+type churnResult struct {
+	Create   []time.Duration
+	Stop     []time.Duration
+	Failures int
+	Replaced int
+}
+
+// churnInterval is the time between replacements at rate a second, at least a nanosecond as a ticker can't tick any faster
+func churnInterval(rate float64) time.Duration {
+	interval := time.Duration(float64(time.Second) / rate)
+	if interval < 1 {
+		return 1
+	}
+	return interval
+}
+
+// churn replaces the oldest pod with a new one rate times a second for duration, keeping the population steady.
+// New pods are numbered from first, it returns the next unused number along with what it measured
+func churn(ctx context.Context, rt *cri.Runtime, podConfigFile string, first int, rate float64, duration time.Duration, threads int) (churnResult, int) {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		result churnResult
+	)
+
+	l := limiter.New(threads)
+	ticker := time.NewTicker(churnInterval(rate))
+	defer ticker.Stop()
+	deadline := time.After(duration)
+
+	next := first
+	for {
+		select {
+		case <-deadline:
+			wg.Wait()
+			return result, next
+		case <-ticker.C:
+		}
+
+		uid := strconv.Itoa(next)
+		next++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// stops are only counted when there was a pod to replace and it stopped, a failed stop has no duration
+			var stopped []time.Duration
+			if old, ok := takeOldestPod(); ok {
+				l.Begin()
+				stopPod(ctx, rt, &old, l)
+				removePod(ctx, rt, &old)
+				if old.DestructionTime > 0 {
+					stopped = append(stopped, old.DestructionTime)
+				}
+			}
+
+			l.Begin()
+			latency, err := createPod(ctx, rt, podConfigFile, uid, l)
+
+			mu.Lock()
+			defer mu.Unlock()
+			result.Replaced++
+			result.Stop = append(result.Stop, stopped...)
+			if err != nil {
+				result.Failures++
+				return
+			}
+			result.Create = append(result.Create, latency)
+		}()
+	}
+}
+
+// ChurnWindow is what one window of a churn test measured
+type ChurnWindow struct {
+	Name       string        `json:"name"`
+	Elapsed    time.Duration `json:"elapsed"`
+	Replaced   int           `json:"replaced"`
+	Failures   int           `json:"failures"`
+	CreateP50  time.Duration `json:"createP50"`
+	CreateP99  time.Duration `json:"createP99"`
+	StopP50    time.Duration `json:"stopP50"`
+	StopP99    time.Duration `json:"stopP99"`
+	Memory     uint64        `json:"memory"`
+	CPUPercent float64       `json:"cpuPercent"`
+}
+
+// ChurnResult is the outcome of a churn test, drift is the least squares slope across the windows per minute
+type ChurnResult struct {
+	Population      int           `json:"population"`
+	Rate            float64       `json:"rate"`
+	Windows         []ChurnWindow `json:"windows"`
+	MemoryDrift     float64       `json:"memoryDrift"`
+	CPUDrift        float64       `json:"cpuDrift"`
+	CreateP99Drift  time.Duration `json:"createP99Drift"`
+	InitialFailures int           `json:"initialFailures"`
+}
+
+// ChurnTest fills the node to population pods, then replaces the oldest pod rate times a second for duration.
+// Latency and runtime usage are reported for every window so drift over a long run shows up
+func ChurnTest(testFlags *TestFlags, population int, rate float64, duration, window time.Duration) error {
+	if population < 1 || rate <= 0 || window <= 0 {
+		return fmt.Errorf("population, rate and window must be more than zero")
+	}
+
+	run, err := startRun(testFlags, "churn")
+	if err != nil {
+		return err
+	}
+	defer run.close()
+	rt, ctx, sampler := run.rt, run.ctx, run.sampler
+
+	result := &ChurnResult{Population: population, Rate: rate}
+
+	logging.FromContext(ctx).WithField(logging.Phase, "create").Infof("Starting %d pods", population)
+	_, result.InitialFailures = createPods(ctx, rt, testFlags.PodConfigFile, 0, population, testFlags.Threads)
+	next := population
+
+	// the fill is not steady state, cpu is measured from here
+	metricsRuntime := []stats.Metrics{}
+	if total, err := sampler.Sample("filled"); err != nil {
+		logging.FromContext(ctx).WithError(err).Error("unable to sample the runtime cgroup")
+	} else {
+		metricsRuntime = append(metricsRuntime, *total)
+	}
+
+	logging.FromContext(ctx).WithField(logging.Phase, "churn").Infof("Replacing %.2f pods a second for %s", rate, duration)
+	start := time.Now()
+	for elapsed := time.Duration(0); elapsed < duration; elapsed = time.Since(start) {
+		length := window
+		if duration-elapsed < length {
+			length = duration - elapsed
+		}
+
+		var churned churnResult
+		churned, next = churn(logging.WithField(ctx, logging.Phase, "churn"), rt, testFlags.PodConfigFile, next, rate, length, testFlags.Threads)
+
+		w := ChurnWindow{
+			Name:      fmt.Sprintf("window-%d", len(result.Windows)+1),
+			Elapsed:   time.Since(start).Round(time.Second),
+			Replaced:  churned.Replaced,
+			Failures:  churned.Failures,
+			CreateP50: stats.Percentile(churned.Create, 50),
+			CreateP99: stats.Percentile(churned.Create, 99),
+			StopP50:   stats.Percentile(churned.Stop, 50),
+			StopP99:   stats.Percentile(churned.Stop, 99),
+		}
+		if total, err := sampler.Sample(w.Name); err != nil {
+			logging.FromContext(ctx).WithError(err).Error("unable to sample the runtime cgroup")
+		} else {
+			metricsRuntime = append(metricsRuntime, *total)
+			w.Memory, w.CPUPercent = total.Mem, total.CPUPercent*100
+		}
+		result.Windows = append(result.Windows, w)
+	}
+	result.MemoryDrift, result.CPUDrift, result.CreateP99Drift = churnDrift(result.Windows)
+
+	logging.FromContext(ctx).WithField(logging.Phase, "stop").Info("Stopping pods")
+	stopPods(ctx, rt, testFlags.Threads)
+	removeStoppedPods(ctx, rt)
+	tracker.Stop()
+
+	finishRun(run, &Results{Runtime: metricsRuntime, Churn: result},
+		resultSection{"Churn", func() { ChurnWriter(result) }})
+	return nil
+}
+
+// churnDrift fits a line through each windows memory, cpu and create p99 against the minutes elapsed
+func churnDrift(windows []ChurnWindow) (memory, cpu float64, createP99 time.Duration) {
+	minutes := make([]float64, 0, len(windows))
+	mem := make([]float64, 0, len(windows))
+	cpus := make([]float64, 0, len(windows))
+	p99 := make([]float64, 0, len(windows))
+	for _, w := range windows {
+		minutes = append(minutes, w.Elapsed.Minutes())
+		mem = append(mem, float64(w.Memory))
+		cpus = append(cpus, w.CPUPercent)
+		p99 = append(p99, float64(w.CreateP99))
+	}
+	memory, _ = stats.Fit(minutes, mem)
+	cpu, _ = stats.Fit(minutes, cpus)
+	p99Slope, _ := stats.Fit(minutes, p99)
+	return memory, cpu, time.Duration(p99Slope)
+}
+
+// ChurnWriter writes the churn windows and drift to the terminal
+func ChurnWriter(result *ChurnResult) {
+	tableWriter := table.NewWriter()
+	tableWriter.SetOutputMirror(os.Stdout)
+	tableWriter.AppendHeader(table.Row{"Window", "Elapsed", "Replaced", "Failures", "Create p50", "Create p99", "Stop p50", "Stop p99", "Memory", "CPU %"})
+	for _, w := range result.Windows {
+		tableWriter.AppendRow(table.Row{w.Name, w.Elapsed, w.Replaced, w.Failures, w.CreateP50, w.CreateP99, w.StopP50, w.StopP99,
+			w.Memory, fmt.Sprintf("%.1f", w.CPUPercent)})
+	}
+	tableWriter.AppendFooter(table.Row{"drift / min", "", "", "", "", result.CreateP99Drift, "", "",
+		fmt.Sprintf("%.2f", result.MemoryDrift), fmt.Sprintf("%.2f", result.CPUDrift)})
+	tableWriter.Render()
+}
@@ -0,0 +1,52 @@
+package tests
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestChurnDrift(t *testing.T) {
+	tests := []struct {
+		name      string
+		windows   []ChurnWindow
+		memory    float64
+		cpu       float64
+		createP99 time.Duration
+	}{
+		{"no windows", nil, 0, 0, 0},
+		{"one window", []ChurnWindow{{Elapsed: time.Minute, Memory: 100, CPUPercent: 5, CreateP99: time.Second}}, 0, 0, 0},
+		{"steady", []ChurnWindow{
+			{Elapsed: time.Minute, Memory: 100, CPUPercent: 5, CreateP99: time.Second},
+			{Elapsed: 2 * time.Minute, Memory: 100, CPUPercent: 5, CreateP99: time.Second},
+		}, 0, 0, 0},
+		{"drifting", []ChurnWindow{
+			{Elapsed: time.Minute, Memory: 100, CPUPercent: 5, CreateP99: time.Second},
+			{Elapsed: 2 * time.Minute, Memory: 110, CPUPercent: 6, CreateP99: 2 * time.Second},
+			{Elapsed: 3 * time.Minute, Memory: 120, CPUPercent: 7, CreateP99: 3 * time.Second},
+		}, 10, 1, time.Second},
+	}
+	for _, tt := range tests {
+		memory, cpu, createP99 := churnDrift(tt.windows)
+		if math.Abs(memory-tt.memory) > 1e-9 || math.Abs(cpu-tt.cpu) > 1e-9 || createP99 != tt.createP99 {
+			t.Errorf("%s: expected %v %v %v found %v %v %v", tt.name, tt.memory, tt.cpu, tt.createP99, memory, cpu, createP99)
+		}
+	}
+}
+
+func TestChurnInterval(t *testing.T) {
+	tests := []struct {
+		rate float64
+		want time.Duration
+	}{
+		{1, time.Second},
+		{4, 250 * time.Millisecond},
+		{1e9, time.Nanosecond},
+		{1e12, time.Nanosecond},
+	}
+	for _, tt := range tests {
+		if got := churnInterval(tt.rate); got != tt.want {
+			t.Errorf("rate %v: expected %s found %s", tt.rate, tt.want, got)
+		}
+	}
+}
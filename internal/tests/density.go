@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Klaven/cospeck/internal/logging"
+	"github.com/Klaven/cospeck/internal/stats"
+	"github.com/jedib0t/go-pretty/table"
+)
+
+// DensityStep is what was measured once the node settled at a pod count
+type DensityStep struct {
+	Pods       int           `json:"pods"`
+	Failures   int           `json:"failures"`
+	CreateP50  time.Duration `json:"createP50"`
+	CreateP99  time.Duration `json:"createP99"`
+	Memory     uint64        `json:"memory"`
+	CPUPercent float64       `json:"cpuPercent"`
+	NodeMemory uint64        `json:"nodeMemory"`
+}
+
+// DensityResult is the outcome of a density ramp, the marginal costs are least squares slopes against the pod count
+type DensityResult struct {
+	Steps            []DensityStep `json:"steps"`
+	MemoryPerPod     float64       `json:"memoryPerPod"`
+	CPUPerPod        float64       `json:"cpuPerPod"`
+	NodeMemoryPerPod float64       `json:"nodeMemoryPerPod"`
+	CreateP99PerPod  time.Duration `json:"createP99PerPod"`
+	// BaseMemory and BaseNodeMemory are where the fits cross zero pods
+	BaseMemory     float64 `json:"baseMemory"`
+	BaseNodeMemory float64 `json:"baseNodeMemory"`
+}
+
+// DensityTest adds step pods at a time up to max pods, letting the node settle for settle at each step before the
+// runtime and node are measured. The runtime memory and cpu, node memory and create latency are fitted against the
+// pod count to give the marginal cost of one more pod
+func DensityTest(testFlags *TestFlags, step, max int, settle time.Duration) error {
+	if step < 1 || max < step {
+		return fmt.Errorf("step must be at least 1 and max at least step")
+	}
+
+	run, err := startRun(testFlags, "density")
+	if err != nil {
+		return err
+	}
+	defer run.close()
+	rt, ctx, sampler := run.rt, run.ctx, run.sampler
+
+	metricsRuntime := []stats.Metrics{}
+	measure := func(name string, pods int) DensityStep {
+		s := DensityStep{Pods: pods}
+		// the sample before settling resets the cpu baseline, so cpu is measured over the settled period only
+		if _, err := sampler.Sample(name); err != nil {
+			logging.FromContext(ctx).WithError(err).Error("unable to sample the runtime cgroup")
+		}
+		time.Sleep(settle)
+		if total, err := sampler.Sample(name); err != nil {
+			logging.FromContext(ctx).WithError(err).Error("unable to sample the runtime cgroup")
+		} else {
+			metricsRuntime = append(metricsRuntime, *total)
+			s.Memory, s.CPUPercent = total.Mem, total.CPUPercent*100
+		}
+		s.NodeMemory, _ = stats.NodeMemory()
+		return s
+	}
+
+	result := &DensityResult{}
+	result.Steps = append(result.Steps, measure("0 pods", 0))
+
+	running, next := 0, 0
+	for target := step; target <= max; target += step {
+		sctx := logging.WithField(ctx, logging.Phase, "ramp")
+		logging.FromContext(sctx).Infof("Ramping to %d pods", target)
+		latencies, failures := createPods(sctx, rt, testFlags.PodConfigFile, next, target-running, testFlags.Threads)
+		next += target - running
+		running += len(latencies)
+
+		s := measure(fmt.Sprintf("%d pods", running), running)
+		s.Failures = failures
+		s.CreateP50 = stats.Percentile(latencies, 50)
+		s.CreateP99 = stats.Percentile(latencies, 99)
+		result.Steps = append(result.Steps, s)
+	}
+	densityFit(result)
+
+	logging.FromContext(ctx).WithField(logging.Phase, "stop").Info("Stopping pods")
+	stopPods(ctx, rt, testFlags.Threads)
+	removeStoppedPods(ctx, rt)
+	tracker.Stop()
+
+	finishRun(run, &Results{Runtime: metricsRuntime, Density: result},
+		resultSection{"Density", func() { DensityWriter(result) }})
+	return nil
+}
+
+// densityFit fits each measurement against the pod count, create latency only over the steps that created pods
+func densityFit(result *DensityResult) {
+	var pods, mem, cpu, node, createPods, p99 []float64
+	for _, s := range result.Steps {
+		pods = append(pods, float64(s.Pods))
+		mem = append(mem, float64(s.Memory))
+		cpu = append(cpu, s.CPUPercent)
+		node = append(node, float64(s.NodeMemory))
+		if s.CreateP99 > 0 {
+			createPods = append(createPods, float64(s.Pods))
+			p99 = append(p99, float64(s.CreateP99))
+		}
+	}
+
+	result.MemoryPerPod, result.BaseMemory = stats.Fit(pods, mem)
+	result.CPUPerPod, _ = stats.Fit(pods, cpu)
+	result.NodeMemoryPerPod, result.BaseNodeMemory = stats.Fit(pods, node)
+	p99PerPod, _ := stats.Fit(createPods, p99)
+	result.CreateP99PerPod = time.Duration(p99PerPod)
+}
+
+// DensityWriter writes the density steps and the marginal cost per pod to the terminal
+func DensityWriter(result *DensityResult) {
+	tableWriter := table.NewWriter()
+	tableWriter.SetOutputMirror(os.Stdout)
+	tableWriter.AppendHeader(table.Row{"Pods", "Failures", "Create p50", "Create p99", "Runtime Memory", "Runtime CPU %", "Node Memory"})
+	for _, s := range result.Steps {
+		tableWriter.AppendRow(table.Row{s.Pods, s.Failures, s.CreateP50, s.CreateP99, s.Memory, fmt.Sprintf("%.1f", s.CPUPercent), s.NodeMemory})
+	}
+	tableWriter.AppendFooter(table.Row{"per pod", "", "", result.CreateP99PerPod, fmt.Sprintf("%.2f", result.MemoryPerPod),
+		fmt.Sprintf("%.3f", result.CPUPerPod), fmt.Sprintf("%.2f", result.NodeMemoryPerPod)})
+	tableWriter.Render()
+}
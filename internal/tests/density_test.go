@@ -0,0 +1,36 @@
+package tests
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDensityFit(t *testing.T) {
+	result := &DensityResult{Steps: []DensityStep{
+		{Pods: 10, Memory: 150, CPUPercent: 2, NodeMemory: 1100, CreateP99: time.Second},
+		{Pods: 20, Memory: 200, CPUPercent: 3, NodeMemory: 1200},
+		{Pods: 30, Memory: 250, CPUPercent: 4, NodeMemory: 1300, CreateP99: 3 * time.Second},
+	}}
+	densityFit(result)
+
+	tests := []struct {
+		name      string
+		got, want float64
+	}{
+		{"memory per pod", result.MemoryPerPod, 5},
+		{"base memory", result.BaseMemory, 100},
+		{"cpu per pod", result.CPUPerPod, 0.1},
+		{"node memory per pod", result.NodeMemoryPerPod, 10},
+		{"base node memory", result.BaseNodeMemory, 1000},
+	}
+	for _, tt := range tests {
+		if math.Abs(tt.got-tt.want) > 1e-9 {
+			t.Errorf("%s: expected %v found %v", tt.name, tt.want, tt.got)
+		}
+	}
+	// the step without a create latency is left out of the fit rather than counted as zero
+	if result.CreateP99PerPod != 100*time.Millisecond {
+		t.Errorf("Expected create p99 to grow 100ms a pod found %s", result.CreateP99PerPod)
+	}
+}
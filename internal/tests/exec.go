@@ -0,0 +1,161 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Klaven/cospeck/internal/logging"
+	"github.com/Klaven/cospeck/internal/runtime/cri"
+	"github.com/Klaven/cospeck/internal/stats"
+	"github.com/jedib0t/go-pretty/table"
+)
+
+// execTimeout is how long a single exec may take before the runtime kills it
+const execTimeout = 30 * time.Second
+
+// execLoad runs cmd in the running pods round robin, concurrency at a time, until duration is up.
+// It returns the round trip latency of every successful exec and how many failed
+func execLoad(ctx context.Context, rt *cri.Runtime, cmd []string, concurrency int, duration time.Duration) ([]time.Duration, int) {
+	targets := runningPods()
+	if len(targets) == 0 {
+		logging.FromContext(ctx).Warn("no running pods to exec in")
+		return nil, 0
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		latencies []time.Duration
+		failures  int
+	)
+
+	deadline := time.Now().Add(duration)
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := worker; time.Now().Before(deadline); i += concurrency {
+				p := targets[i%len(targets)]
+				containers := (*p.Pod).Containers()
+				if len(containers) == 0 {
+					continue
+				}
+
+				latency, _, err := rt.ExecSync(ctx, containers[0], cmd, execTimeout)
+				mu.Lock()
+				if err != nil {
+					failures++
+					logging.FromContext(p.context(ctx)).WithError(err).Debug("exec failed")
+				} else {
+					latencies = append(latencies, latency)
+				}
+				mu.Unlock()
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	return latencies, failures
+}
+
+// ExecResult is the outcome of an exec test
+type ExecResult struct {
+	Command     []string      `json:"command"`
+	Pods        int           `json:"pods"`
+	Concurrency int           `json:"concurrency"`
+	Duration    time.Duration `json:"duration"`
+	Execs       int           `json:"execs"`
+	Failures    int           `json:"failures"`
+	Latency     LifecycleStat `json:"latency"`
+	// IdleCPUPercent and CPUPercent are the runtimes cpu use before and during the execs,
+	// CPUPerExec is what it used during the execs over idle divided by the number of execs
+	IdleCPUPercent float64       `json:"idleCpuPercent"`
+	CPUPercent     float64       `json:"cpuPercent"`
+	CPUPerExec     time.Duration `json:"cpuPerExec"`
+}
+
+// ExecTest creates pods then runs ExecSync calls against their first containers, concurrency at a time for duration.
+// The runtime cgroup is sampled over an idle period first so the cpu cost of each exec excludes what the runtime uses anyway
+func ExecTest(testFlags *TestFlags, totalPods int, cmd []string, concurrency int, duration, idle time.Duration) error {
+	if totalPods < 1 || concurrency < 1 || duration <= 0 || idle <= 0 {
+		return fmt.Errorf("pods, concurrency, duration and idle must be more than zero")
+	}
+
+	run, err := startRun(testFlags, "exec")
+	if err != nil {
+		return err
+	}
+	defer run.close()
+	rt, ctx, sampler := run.rt, run.ctx, run.sampler
+
+	logging.FromContext(ctx).WithField(logging.Phase, "create").Infof("Starting %d pods", totalPods)
+	created, failures := createPods(ctx, rt, testFlags.PodConfigFile, 0, totalPods, testFlags.Threads)
+	if len(created) == 0 {
+		tracker.Stop()
+		return fmt.Errorf("none of the %d pods started", failures)
+	}
+
+	metricsRuntime := []stats.Metrics{}
+	sample := func(name string) *stats.Metrics {
+		m, err := sampler.Sample(name)
+		if err != nil {
+			logging.FromContext(ctx).WithError(err).Error("unable to sample the runtime cgroup")
+			return &stats.Metrics{Name: name}
+		}
+		metricsRuntime = append(metricsRuntime, *m)
+		return m
+	}
+
+	sample("pods-created")
+	time.Sleep(idle)
+	idleSample := sample("idle")
+
+	logging.FromContext(ctx).WithField(logging.Phase, "exec").Infof("Running %v in %d pods, %d at a time for %s", cmd, len(created), concurrency, duration)
+	start := time.Now()
+	latencies, execFailures := execLoad(logging.WithField(ctx, logging.Phase, "exec"), rt, cmd, concurrency, duration)
+	elapsed := time.Since(start)
+	execSample := sample("exec")
+
+	logging.FromContext(ctx).WithField(logging.Phase, "stop").Info("Stopping pods")
+	stopPods(ctx, rt, testFlags.Threads)
+	removeStoppedPods(ctx, rt)
+	tracker.Stop()
+
+	result := &ExecResult{
+		Command:        cmd,
+		Pods:           len(created),
+		Concurrency:    concurrency,
+		Duration:       elapsed,
+		Execs:          len(latencies),
+		Failures:       execFailures,
+		Latency:        newLifecycleStat("exec", latencies),
+		IdleCPUPercent: idleSample.CPUPercent * 100,
+		CPUPercent:     execSample.CPUPercent * 100,
+	}
+	if result.Execs > 0 {
+		// cpu used over what the runtime would have used idle for as long
+		extra := execSample.CPU - idleSample.CPUPercent*float64(elapsed.Nanoseconds())
+		if extra > 0 {
+			result.CPUPerExec = time.Duration(extra / float64(result.Execs))
+		}
+	}
+
+	finishRun(run, &Results{Runtime: metricsRuntime, Exec: result},
+		resultSection{"Exec", func() { ExecWriter(result) }},
+		resultSection{"Runtime Metrics", func() { MetricsWriter(&metricsRuntime) }})
+	return nil
+}
+
+// ExecWriter writes the exec test results to the terminal
+func ExecWriter(result *ExecResult) {
+	tableWriter := table.NewWriter()
+	tableWriter.SetOutputMirror(os.Stdout)
+	tableWriter.AppendHeader(table.Row{"Execs", "Failures", "Per Second", "p50", "p90", "p99", "Max", "CPU % (idle)", "CPU per Exec"})
+	tableWriter.AppendRow(table.Row{result.Execs, result.Failures, fmt.Sprintf("%.1f", float64(result.Execs)/result.Duration.Seconds()),
+		result.Latency.P50, result.Latency.P90, result.Latency.P99, result.Latency.Max,
+		fmt.Sprintf("%.1f (%.1f)", result.CPUPercent, result.IdleCPUPercent), result.CPUPerExec})
+	tableWriter.Render()
+}
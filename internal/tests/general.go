@@ -3,21 +3,36 @@ package tests
 import (
 	"context"
 	"fmt"
+	"math/rand"
 	"strconv"
 	"sync"
 	"time"
 
+	"github.com/Klaven/cospeck/internal/logging"
+	"github.com/Klaven/cospeck/internal/progress"
 	"github.com/Klaven/cospeck/internal/runtime"
 	"github.com/Klaven/cospeck/internal/runtime/cri"
 	"github.com/Klaven/cospeck/internal/stats"
+	"github.com/Klaven/cospeck/internal/trace"
+	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/limiter"
 )
 
 type testPod struct {
+	Index           string
 	CreationTime    time.Duration
+	InitTime        time.Duration
 	DestructionTime time.Duration
 	AverageMemory   int64
+	Stopped         bool
 	Pod             *runtime.Pod
+	Span            *trace.Span
+}
+
+// context returns a context carrying the pods trace span and log fields
+func (p *testPod) context(ctx context.Context) context.Context {
+	ctx = logging.WithFields(ctx, log.Fields{logging.PodIndex: p.Index, logging.PodID: (*p.Pod).PodID()})
+	return trace.WithSpan(ctx, p.Span)
 }
 
 // Find finds a pod in a list of test pods
@@ -33,26 +48,31 @@ func Find(a []testPod, x runtime.Pod) int {
 var (
 	mutex = &sync.Mutex{}
 	pods  = make([]testPod, 0)
+	// tracker draws the progress line, nil when progress is off
+	tracker *progress.Tracker
 )
 
 // GeneralTest is a very basic general test of memory and CPU
 func GeneralTest(testFlags *TestFlags, totalPods int) {
 
-	fmt.Println("Running tests")
+	log.Info("Running tests")
 
 	sampler, err := stats.NewCGroupsSampler(testFlags.CGroupPath)
 	if err != nil {
-		fmt.Println(err)
+		log.WithError(err).Error("unable to create cgroup sampler")
 		return
 	}
 
-	rt, err := cri.NewCRIRuntime(testFlags.OCIRuntime, 30*time.Second, nil, nil)
+	rt, err := newRuntime(testFlags)
 	if err != nil {
-		fmt.Println(err)
+		log.WithError(err).Error("unable to connect to the runtime")
 		return
 	}
 
-	ctx := context.Background()
+	tracer := newTracer(testFlags)
+	ctx := trace.WithTracer(context.Background(), tracer)
+	meta := newRunMetadata(ctx, rt, testFlags, "general")
+	ctx = logging.WithField(ctx, logging.RunID, meta.RunID)
 	rt.Clean(ctx)
 	defer rt.Clean(ctx)
 	// removes all pods before we start
@@ -63,43 +83,54 @@ func GeneralTest(testFlags *TestFlags, totalPods int) {
 	metricsRuntime := []stats.Metrics{}
 	total, err := sampler.Sample("init")
 	if err != nil {
-		fmt.Println(err)
+		logging.FromContext(ctx).WithError(err).Error("unable to sample the runtime cgroup")
 	}
 	metricsRuntime = append(metricsRuntime, *total)
 
 	metricsContainers := []stats.MetricsV2{}
 	totalContainers, err := stats.Stats(rt, "init")
 	if err != nil {
-		fmt.Println(err)
+		logging.FromContext(ctx).WithError(err).Error("unable to get container stats")
 	}
 	metricsContainers = append(metricsContainers, *totalContainers)
 
-	fmt.Println("Starting Pods")
+	logging.FromContext(ctx).WithField(logging.Phase, "create").Infof("Starting %d pods", totalPods)
 
 	l := limiter.New(testFlags.Threads)
 
-	for i := 0; i < totalPods; i++ {
-		fmt.Println("starting pod number: ", i)
-		runNumberAsString := strconv.Itoa(i)
-		l.Begin()
-		go createPod(ctx, rt, testFlags.PodConfigFile, runNumberAsString, l)
-	}
-
-	println("Finished Starting Pods")
+	tracker = progress.New(testFlags.Progress, sampler.Memory)
+	tracker.Start(progressInterval)
 
-	if err != nil {
-		fmt.Println("Failed to get cgroup sampler")
+	var openLoopRows []LifecycleStat
+	if testFlags.ArrivalRate > 0 {
+		schedule, err := arrivals(totalPods, testFlags.ArrivalRate, testFlags.Arrival, rand.New(rand.NewSource(arrivalSeed(meta.RunID))))
+		if err != nil {
+			logging.FromContext(ctx).WithError(err).Error("unable to schedule pods")
+			tracker.Stop()
+			return
+		}
+		logging.FromContext(ctx).WithField(logging.Phase, "create").Infof("Open loop, %.2f pods a second with %s arrivals", testFlags.ArrivalRate, testFlags.Arrival)
+		result := openLoop(ctx, rt, testFlags.PodConfigFile, 0, totalPods, schedule, testFlags.MaxInFlight)
+		openLoopRows = result.rows()
+	} else {
+		for i := 0; i < totalPods; i++ {
+			runNumberAsString := strconv.Itoa(i)
+			l.Begin()
+			go createPod(ctx, rt, testFlags.PodConfigFile, runNumberAsString, l)
+		}
 	}
 
+	logging.FromContext(ctx).WithField(logging.Phase, "create").Info("Finished starting pods")
+
 	total, err = sampler.Sample("pods-created")
 	if err != nil {
-		fmt.Println(err)
+		logging.FromContext(ctx).WithError(err).Error("unable to sample the runtime cgroup")
 	}
 	metricsRuntime = append(metricsRuntime, *total)
 
 	totalContainers, err = stats.Stats(rt, "pods-created")
 	if err != nil {
-		fmt.Println(err)
+		logging.FromContext(ctx).WithError(err).Error("unable to get container stats")
 	}
 	metricsContainers = append(metricsContainers, *totalContainers)
 
@@ -108,36 +139,48 @@ func GeneralTest(testFlags *TestFlags, totalPods int) {
 
 	total, err = sampler.Sample("sleep-10")
 	if err != nil {
-		fmt.Println(err)
+		logging.FromContext(ctx).WithError(err).Error("unable to sample the runtime cgroup")
 	}
 	metricsRuntime = append(metricsRuntime, *total)
 
 	totalContainers, err = stats.Stats(rt, "sleep-10")
 	if err != nil {
-		fmt.Println(err)
+		logging.FromContext(ctx).WithError(err).Error("unable to get container stats")
 	}
 	metricsContainers = append(metricsContainers, *totalContainers)
 
-	fmt.Println("")
-	fmt.Println("Stopping Pods")
-	for _, p := range pods {
+	logging.FromContext(ctx).WithField(logging.Phase, "stop").Info("Stopping pods")
+	for i := range pods {
 		l.Begin()
-		stopPod(ctx, rt, &p, l)
+		stopPod(ctx, rt, &pods[i], l)
+	}
+	for i := range pods {
+		removePod(ctx, rt, &pods[i])
 	}
+	tracker.Stop()
+	lifecycle := append(podLifecycle(pods), openLoopRows...)
 
 	total, err = sampler.Sample("stopping")
 	if err != nil {
-		fmt.Println(err)
+		logging.FromContext(ctx).WithError(err).Error("unable to sample the runtime cgroup")
 	}
 	metricsRuntime = append(metricsRuntime, *total)
 
 	totalContainers, err = stats.Stats(rt, "stopping")
 	if err != nil {
-		fmt.Println(err)
+		logging.FromContext(ctx).WithError(err).Error("unable to get container stats")
 	}
 
 	metricsContainers = append(metricsContainers, *totalContainers)
 
+	fmt.Println("--Run--")
+	MetadataWriter(meta)
+
+	fmt.Println("")
+	fmt.Println("--Pod Lifecycle--")
+	LifecycleWriter(lifecycle)
+
+	fmt.Println("")
 	fmt.Println("--Container Metrics--")
 	MetricsV2Writer(&metricsContainers)
 
@@ -145,6 +188,17 @@ func GeneralTest(testFlags *TestFlags, totalPods int) {
 	fmt.Println("--Runtime Metrics--")
 	MetricsWriter(&metricsRuntime)
 
+	err = ResultsWriter(testFlags, &Results{
+		Metadata:   meta,
+		Runtime:    metricsRuntime,
+		Containers: metricsContainers,
+		Lifecycle:  lifecycle,
+	})
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).Error("unable to write results")
+	}
+	exportTrace(ctx, testFlags, tracer)
+
 	//TODO: check to make sure namesapce is cleaned up first (and maybe should create the namespace, failing if it exists)
 	//TODO: fail if not clean
 
@@ -152,38 +206,81 @@ func GeneralTest(testFlags *TestFlags, totalPods int) {
 
 func stopPod(ctx context.Context, runtime *cri.Runtime, pod *testPod, finished *limiter.Limiter) {
 	defer finished.End()
+	ctx = logging.WithField(pod.context(ctx), logging.Phase, "stop")
 	duration, err := runtime.StopPod(ctx, pod.Pod, "")
 	if err != nil {
-		fmt.Println("duration:", duration)
-		fmt.Println(err)
+		logging.FromContext(ctx).WithError(err).WithField("duration", duration).Error("unable to stop pod")
 	}
+	tracker.Stopped()
 	pod.DestructionTime = duration
+	pod.Stopped = true
+}
+
+// removePod removes the pod sandbox, ending the pods lifecycle
+func removePod(ctx context.Context, runtime *cri.Runtime, pod *testPod) {
+	defer pod.Span.Finish()
+	ctx = logging.WithField(pod.context(ctx), logging.Phase, "remove")
+	_, err := runtime.RemovePod(ctx, pod.Pod, "")
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).Error("unable to remove pod")
+	}
 }
 
-func createPod(ctx context.Context, runtime runtime.Runtime, podConfigFile string, uid string, finished *limiter.Limiter) error {
+// createPod creates and starts a pod, returning how long it took to become ready
+func createPod(ctx context.Context, runtime runtime.Runtime, podConfigFile string, uid string, finished *limiter.Limiter) (time.Duration, error) {
 	defer finished.End()
+	ctx, span := trace.Start(ctx, "pod")
+	span.SetAttribute("pod.index", uid)
+	ctx = logging.WithFields(ctx, log.Fields{logging.PodIndex: uid, logging.Phase: "create"})
+	logging.FromContext(ctx).Debug("starting pod")
+
 	start := time.Now()
 	pod, err := runtime.CreatePodAndContainerFromSpec(ctx, podConfigFile, uid)
 
 	if err != nil {
-		fmt.Println(err)
-		return err
+		logging.FromContext(ctx).WithError(err).Error("unable to create pod")
+		tracker.Failed()
+		span.SetError(err)
+		span.Finish()
+		return 0, err
 	}
+	span.SetAttribute("pod.id", pod.PodID())
+	ctx = logging.WithField(ctx, logging.PodID, pod.PodID())
+	tracker.Created()
 
 	for _, c := range pod.Containers() {
 		_, err = runtime.Run(ctx, c)
 		if err != nil {
-			fmt.Println("error starting container you dumb dumb: ", err)
-			return err
+			logging.FromContext(ctx).WithError(err).WithField("container", c.Name()).Error("unable to start container")
+			tracker.Failed()
+			span.SetError(err)
+			span.Finish()
+			return 0, err
+		}
+	}
+
+	// a container that never became ready is a failed create, not a slow one
+	for _, c := range pod.Containers() {
+		if _, err := runtime.WaitRunning(ctx, c); err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("container", c.Name()).Error("container not ready")
+			tracker.Failed()
+			span.SetError(err)
+			span.Finish()
+			return 0, err
 		}
 	}
+	logging.FromContext(ctx).Debug("pod started")
 
 	elapsed := time.Since(start)
+	tracker.Running(elapsed)
 	mutex.Lock()
 	pods = append(pods, testPod{
+		Index:        uid,
 		Pod:          &pod,
 		CreationTime: elapsed,
+		InitTime:     pod.InitTime(),
+		Span:         span,
 	})
 	mutex.Unlock()
-	return nil
+	return elapsed, nil
 }
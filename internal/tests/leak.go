@@ -0,0 +1,243 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Klaven/cospeck/internal/logging"
+	"github.com/Klaven/cospeck/internal/stats"
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultStateDirs are where containerd and cri-o keep per pod and per container state, the ones that don't exist are skipped
+var DefaultStateDirs = []string{
+	"/run/containerd/io.containerd.runtime.v2.task/k8s.io",
+	"/var/lib/containerd/io.containerd.grpc.v1.cri/sandboxes",
+	"/var/lib/containerd/io.containerd.grpc.v1.cri/containers",
+	"/run/containers/storage/overlay-containers",
+	"/var/lib/containers/storage/overlay-containers",
+}
+
+const (
+	// rssTolerance is how much the daemon RSS may grow before it is called a leak, allocators don't give memory back promptly
+	rssTolerance = 0.05
+	// a count has to go up in at least leakCycles cycles, or by more than leakJump of its baseline, to be called a leak.
+	// Growing once is usually a cache being filled or a cleanup that hadn't finished
+	leakCycles = 2
+	leakJump   = 0.5
+)
+
+// LeakMetric is one count watched across the cycles of a leak test, Values has the baseline first and then one per cycle
+type LeakMetric struct {
+	Name     string    `json:"name"`
+	Values   []float64 `json:"values"`
+	Before   float64   `json:"before"`
+	After    float64   `json:"after"`
+	PerCycle float64   `json:"perCycle"`
+	Grows    bool      `json:"grows"`
+}
+
+// LeakResult is the outcome of a leak test
+type LeakResult struct {
+	Cycles       int          `json:"cycles"`
+	PodsPerCycle int          `json:"podsPerCycle"`
+	Metrics      []LeakMetric `json:"metrics"`
+}
+
+// leakSnapshot is every watched count at one point in time, names keeps them in a stable order
+type leakSnapshot struct {
+	names  []string
+	values map[string]float64
+}
+
+func (s *leakSnapshot) set(name string, value float64) {
+	if s.values == nil {
+		s.values = map[string]float64{}
+	}
+	s.names = append(s.names, name)
+	s.values[name] = value
+}
+
+// LeakTest runs full create, stop and remove cycles and reports the daemon and host counts that grow with the number of cycles.
+// Warmup cycles run first and are not counted, runtimes often grow caches on the first pods. Cgroups and network namespaces are
+// only counted under cgroupParent, relative to the hierarchy root, which is found from the first pods created when it is empty.
+// It returns an error if anything grew
+func LeakTest(testFlags *TestFlags, cycles, podsPerCycle, warmup int, settle time.Duration, stateDirs []string, cgroupParent string) error {
+	if cycles < 2 || podsPerCycle < 1 {
+		return fmt.Errorf("a leak test needs at least 2 cycles and 1 pod per cycle")
+	}
+
+	run, err := startRun(testFlags, "leak")
+	if err != nil {
+		return err
+	}
+	defer run.close()
+	rt, ctx, sampler := run.rt, run.ctx, run.sampler
+
+	next := 0
+	cycle := func(ctx context.Context) {
+		createPods(ctx, rt, testFlags.PodConfigFile, next, podsPerCycle, testFlags.Threads)
+		next += podsPerCycle
+		if cgroupParent == "" {
+			cgroupParent = podCGroupParent(ctx)
+		}
+		stopPods(ctx, rt, testFlags.Threads)
+		removeStoppedPods(ctx, rt)
+		time.Sleep(settle)
+	}
+
+	for i := 0; i < warmup; i++ {
+		cycle(logging.WithField(ctx, logging.Phase, "warmup"))
+	}
+
+	if cgroupParent == "" {
+		logging.FromContext(ctx).Warn("the pod cgroup parent is not known yet, run a warmup cycle or pass it to count cgroups and network namespaces")
+	}
+	snapshots := []leakSnapshot{leakSnapshotNow(ctx, sampler, stateDirs, cgroupParent)}
+	for i := 1; i <= cycles; i++ {
+		cctx := logging.WithFields(ctx, log.Fields{logging.Phase: "cycle", "cycle": i})
+		logging.FromContext(cctx).Infof("Starting cycle %d of %d", i, cycles)
+		cycle(cctx)
+		snapshots = append(snapshots, leakSnapshotNow(cctx, sampler, stateDirs, cgroupParent))
+	}
+	tracker.Stop()
+
+	result := &LeakResult{Cycles: cycles, PodsPerCycle: podsPerCycle, Metrics: leakMetrics(snapshots)}
+
+	finishRun(run, &Results{Leak: result},
+		resultSection{"Leaks", func() { LeakWriter(result) }})
+
+	grew := 0
+	for _, m := range result.Metrics {
+		if m.Grows {
+			grew++
+		}
+	}
+	if grew > 0 {
+		return fmt.Errorf("%d of %d counts grew with the number of cycles", grew, len(result.Metrics))
+	}
+	return nil
+}
+
+// podCGroupParent finds the cgroup the runtime puts pods under from the first running container, empty if there is none
+func podCGroupParent(ctx context.Context) string {
+	for _, p := range runningPods() {
+		for _, c := range (*p.Pod).Containers() {
+			cgroup, err := stats.ContainerCGroup(c.ContainerID())
+			if err != nil {
+				logging.FromContext(p.context(ctx)).WithError(err).Debug("unable to find the container cgroup")
+				continue
+			}
+			parent := filepath.Dir(cgroup)
+			logging.FromContext(ctx).Infof("Counting cgroups under %s", parent)
+			return parent
+		}
+	}
+	return ""
+}
+
+// leakSnapshotNow counts the daemons fds, threads and RSS (everything in its cgroup), its mount table, the cgroups under the
+// pod cgroup parent, the network namespaces the daemon and the processes in those cgroups hold and the entries of each state directory
+func leakSnapshotNow(ctx context.Context, sampler *stats.CGroupsSampler, stateDirs []string, cgroupParent string) leakSnapshot {
+	var s leakSnapshot
+
+	pids, err := sampler.Pids()
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).Error("unable to list the runtime processes")
+	}
+	procs := stats.Processes(pids)
+	s.set("daemon fds", float64(procs.FDs))
+	s.set("daemon threads", float64(procs.Threads))
+	s.set("daemon rss (MiB)", float64(procs.RSS)/(1024*1024))
+
+	// the daemons mount namespace, ours if it has no processes
+	mountPid := os.Getpid()
+	if len(pids) > 0 {
+		mountPid = pids[0]
+	}
+	if mounts, err := stats.Mounts(mountPid); err != nil {
+		logging.FromContext(ctx).WithError(err).Error("unable to read the mount table")
+	} else {
+		s.set("mounts", float64(mounts))
+	}
+
+	if cgroupParent != "" {
+		cgroups, podPids, err := stats.CGroupTree(cgroupParent)
+		if err != nil {
+			logging.FromContext(ctx).WithError(err).Error("unable to count cgroups")
+		} else {
+			s.set("cgroups", float64(cgroups))
+			inUse, pinned := stats.NetNamespaces(append(podPids, pids...))
+			s.set("netns in use", float64(inUse))
+			s.set("netns pinned", float64(pinned))
+		}
+	}
+
+	for _, dir := range stateDirs {
+		entries, err := stats.DirEntries(dir)
+		if err != nil {
+			logging.FromContext(ctx).WithError(err).Debug("skipping state directory")
+			continue
+		}
+		s.set(dir, float64(entries))
+	}
+	return s
+}
+
+// leakMetrics fits a line through every count against the cycle number, a count grows if it ended higher than it started,
+// the fit goes up and it went up in more than one cycle or by more than leakJump at once. Counts missing from any snapshot are dropped
+func leakMetrics(snapshots []leakSnapshot) []LeakMetric {
+	metrics := []LeakMetric{}
+	for _, name := range snapshots[0].names {
+		m := LeakMetric{Name: name}
+		xs := []float64{}
+		for i, s := range snapshots {
+			v, ok := s.values[name]
+			if !ok {
+				break
+			}
+			xs = append(xs, float64(i))
+			m.Values = append(m.Values, v)
+		}
+		if len(m.Values) != len(snapshots) {
+			continue
+		}
+
+		m.Before, m.After = m.Values[0], m.Values[len(m.Values)-1]
+		m.PerCycle, _ = stats.Fit(xs, m.Values)
+		grew, jump := 0, false
+		for i := 1; i < len(m.Values); i++ {
+			if m.Values[i] > m.Values[i-1] {
+				grew++
+				jump = jump || m.Values[i]-m.Values[i-1] > math.Max(m.Values[0]*leakJump, 1)
+			}
+		}
+		m.Grows = m.After > m.Before && m.PerCycle > 0 && (grew >= leakCycles || jump)
+		if name == "daemon rss (MiB)" && m.After-m.Before <= m.Before*rssTolerance {
+			m.Grows = false
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// LeakWriter writes the leak test counts to the terminal
+func LeakWriter(result *LeakResult) {
+	tableWriter := table.NewWriter()
+	tableWriter.SetOutputMirror(os.Stdout)
+	tableWriter.AppendHeader(table.Row{"Count", "Before", "After", "Per Cycle", "Result"})
+	for _, m := range result.Metrics {
+		status := "ok"
+		if m.Grows {
+			status = "GROWS"
+		}
+		tableWriter.AppendRow(table.Row{m.Name, fmt.Sprintf("%.0f", m.Before), fmt.Sprintf("%.0f", m.After), fmt.Sprintf("%.2f", m.PerCycle), status})
+	}
+	tableWriter.AppendFooter(table.Row{"cycles", result.Cycles, "pods per cycle", result.PodsPerCycle, ""})
+	tableWriter.Render()
+}
@@ -0,0 +1,48 @@
+package tests
+
+import "testing"
+
+func TestLeakMetrics(t *testing.T) {
+	snapshots := func(name string, values ...float64) []leakSnapshot {
+		s := make([]leakSnapshot, len(values))
+		for i, v := range values {
+			s[i].set(name, v)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name      string
+		snapshots []leakSnapshot
+		grows     bool
+	}{
+		{"flat", snapshots("mounts", 10, 10, 10, 10), false},
+		{"shrinks", snapshots("mounts", 12, 11, 10, 10), false},
+		{"grows every cycle", snapshots("mounts", 10, 11, 12, 13), true},
+		{"back where it started", snapshots("mounts", 10, 12, 11, 10), false},
+		{"grows once", snapshots("mounts", 10, 10, 11, 11), false},
+		{"jumps once", snapshots("mounts", 10, 10, 20, 20), true},
+		{"one from nothing", snapshots("cgroups", 0, 0, 1, 1), false},
+		{"grows from nothing", snapshots("cgroups", 0, 1, 2, 3), true},
+		{"rss within tolerance", snapshots("daemon rss (MiB)", 100, 102, 103, 104), false},
+		{"rss over tolerance", snapshots("daemon rss (MiB)", 100, 110, 120, 130), true},
+	}
+	for _, tt := range tests {
+		metrics := leakMetrics(tt.snapshots)
+		if len(metrics) != 1 {
+			t.Errorf("%s: expected one metric found %d", tt.name, len(metrics))
+			continue
+		}
+		if metrics[0].Grows != tt.grows {
+			t.Errorf("%s: expected grows %v found %v (%v)", tt.name, tt.grows, metrics[0].Grows, metrics[0].Values)
+		}
+	}
+
+	// a count missing from a snapshot can't be compared and is left out
+	missing := snapshots("mounts", 10, 11)
+	missing[1] = leakSnapshot{}
+	missing[1].set("threads", 3)
+	if metrics := leakMetrics(missing); len(metrics) != 0 {
+		t.Errorf("Expected a count missing from a snapshot to be left out found %+v", metrics)
+	}
+}
@@ -0,0 +1,167 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Klaven/cospeck/internal/logging"
+	"github.com/Klaven/cospeck/internal/runtime/cri"
+	"github.com/Klaven/cospeck/internal/stats"
+	"github.com/jedib0t/go-pretty/table"
+)
+
+// DefaultLogDirectory is where the logging test has the runtime write container logs, a directory per run
+const DefaultLogDirectory = "/var/log/cospeck"
+
+// LoggingResult is the outcome of a logging test. Throughput only counts lines logged during the measured window,
+// drops and partial lines are counted over the whole of every log file
+type LoggingResult struct {
+	Pods     int           `json:"pods"`
+	Rate     int           `json:"rate"`
+	LineSize int           `json:"lineSize"`
+	Duration time.Duration `json:"duration"`
+	// Expected is the lines a second the containers were asked to write in total
+	Expected       int     `json:"expected"`
+	Lines          int     `json:"lines"`
+	LinesPerSecond float64 `json:"linesPerSecond"`
+	BytesPerSecond float64 `json:"bytesPerSecond"`
+	Dropped        int     `json:"dropped"`
+	Partial        int     `json:"partial"`
+	Malformed      int     `json:"malformed"`
+	// Unreadable is how many containers had no log file to read
+	Unreadable int `json:"unreadable"`
+	// RuntimeCPUPercent is the runtime cgroup, ShimCPUPercent the shim processes found by name, they overlap
+	// when the shims live in the runtimes cgroup
+	RuntimeCPUPercent float64 `json:"runtimeCpuPercent"`
+	ShimCPUPercent    float64 `json:"shimCpuPercent"`
+	Shims             int     `json:"shims"`
+	// CPUPerMiB is the runtime and shim cpu spent for every MiB logged
+	CPUPerMiB time.Duration `json:"cpuPerMiB"`
+}
+
+// LoggingTest runs the logs workload in pods and has the runtime log them under logDir. After running for duration
+// the CRI log files are parsed for the throughput the runtime achieved, dropped and partial lines, and the cpu the runtime
+// and its shims spent doing it. The line rate and size are the logs workload parameters
+func LoggingTest(testFlags *TestFlags, totalPods int, duration time.Duration, logDir string) error {
+	if totalPods < 1 || duration <= 0 {
+		return fmt.Errorf("pods and duration must be more than zero")
+	}
+	if err := requireWorkload(testFlags, "logging", "logs"); err != nil {
+		return err
+	}
+
+	run, err := startRun(testFlags, "logging")
+	if err != nil {
+		return err
+	}
+	defer run.close()
+	rt, ctx, sampler, meta := run.rt, run.ctx, run.sampler, run.meta
+
+	runLogDir := filepath.Join(logDir, meta.RunID)
+	rt.SetLogDirectory(runLogDir)
+	defer os.RemoveAll(runLogDir)
+
+	metricsRuntime := []stats.Metrics{}
+	sample := func(name string) *stats.Metrics {
+		m, err := sampler.Sample(name)
+		if err != nil {
+			logging.FromContext(ctx).WithError(err).Error("unable to sample the runtime cgroup")
+			return &stats.Metrics{Name: name}
+		}
+		metricsRuntime = append(metricsRuntime, *m)
+		return m
+	}
+
+	logging.FromContext(ctx).WithField(logging.Phase, "create").Infof("Starting %d pods", totalPods)
+	created, failures := createPods(ctx, rt, testFlags.PodConfigFile, 0, totalPods, testFlags.Threads)
+	if len(created) == 0 {
+		tracker.Stop()
+		return fmt.Errorf("none of the %d pods started", failures)
+	}
+
+	sample("pods-created")
+	shimsBefore := stats.ProcessCPU(rt.ProcNames())
+	start := time.Now()
+	logging.FromContext(ctx).WithField(logging.Phase, "logging").Infof("Logging for %s", duration)
+	time.Sleep(duration)
+	end := time.Now()
+	window := sample("logging")
+	shimsAfter := stats.ProcessCPU(rt.ProcNames())
+
+	// the pods are stopped before their logs are read so everything written has been flushed
+	targets := runningPods()
+	logging.FromContext(ctx).WithField(logging.Phase, "stop").Info("Stopping pods")
+	stopPods(ctx, rt, testFlags.Threads)
+
+	result := &LoggingResult{
+		Pods:              len(created),
+		Rate:              testFlags.WorkloadParams.LogRate,
+		LineSize:          testFlags.WorkloadParams.LogLineSize,
+		Duration:          end.Sub(start),
+		Expected:          len(created) * testFlags.WorkloadParams.LogRate,
+		RuntimeCPUPercent: window.CPUPercent * 100,
+		Shims:             len(shimsAfter),
+	}
+	var bytes float64
+	for _, p := range targets {
+		for _, c := range (*p.Pod).Containers() {
+			path := ""
+			if cc, ok := c.(*cri.Container); ok {
+				path = cc.LogPath()
+			}
+			clog, err := stats.ReadCRILog(path)
+			if err != nil {
+				result.Unreadable++
+				logging.FromContext(p.context(ctx)).WithError(err).WithField("container", c.Name()).Error("unable to read container log")
+				continue
+			}
+			result.Dropped += clog.Dropped
+			result.Partial += clog.Partial
+			result.Malformed += clog.Malformed
+			inWindow := 0
+			for _, t := range clog.Times {
+				if !t.Before(start) && !t.After(end) {
+					inWindow++
+				}
+			}
+			result.Lines += inWindow
+			// lines are all about the same size, so the bytes in the window follow from the lines in it
+			if clog.Lines > 0 {
+				bytes += float64(clog.Bytes) * float64(inWindow) / float64(clog.Lines)
+			}
+		}
+	}
+	removeStoppedPods(ctx, rt)
+	tracker.Stop()
+
+	var shimCPU time.Duration
+	for pid, after := range shimsAfter {
+		shimCPU += after - shimsBefore[pid]
+	}
+	seconds := result.Duration.Seconds()
+	result.LinesPerSecond = float64(result.Lines) / seconds
+	result.BytesPerSecond = bytes / seconds
+	result.ShimCPUPercent = shimCPU.Seconds() / seconds * 100
+	if mib := bytes / (1024 * 1024); mib > 0 {
+		result.CPUPerMiB = time.Duration((window.CPU + float64(shimCPU)) / mib)
+	}
+
+	finishRun(run, &Results{Runtime: metricsRuntime, Logging: result},
+		resultSection{"Logging", func() { LoggingWriter(result) }},
+		resultSection{"Runtime Metrics", func() { MetricsWriter(&metricsRuntime) }})
+	return nil
+}
+
+// LoggingWriter writes the logging test results to the terminal
+func LoggingWriter(result *LoggingResult) {
+	tableWriter := table.NewWriter()
+	tableWriter.SetOutputMirror(os.Stdout)
+	tableWriter.AppendHeader(table.Row{"Lines/s (asked)", "KiB/s", "Dropped", "Partial", "Malformed", "Runtime CPU %", "Shim CPU % (shims)", "CPU per MiB"})
+	tableWriter.AppendRow(table.Row{fmt.Sprintf("%.0f (%d)", result.LinesPerSecond, result.Expected), fmt.Sprintf("%.1f", result.BytesPerSecond/1024),
+		result.Dropped, result.Partial, result.Malformed,
+		fmt.Sprintf("%.1f", result.RuntimeCPUPercent), fmt.Sprintf("%.1f (%d)", result.ShimCPUPercent, result.Shims), result.CPUPerMiB})
+	tableWriter.AppendFooter(table.Row{"pods", result.Pods, "line size", result.LineSize, "unreadable", result.Unreadable, "", ""})
+	tableWriter.Render()
+}
@@ -0,0 +1,168 @@
+package tests
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/Klaven/cospeck/internal/cospeck"
+	"github.com/Klaven/cospeck/internal/runtime/cri"
+	"github.com/Klaven/cospeck/internal/stats"
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
+)
+
+// RuntimeInfo is what the runtime reports about itself over CRI
+type RuntimeInfo struct {
+	Name       string            `json:"name"`
+	Version    string            `json:"version"`
+	APIVersion string            `json:"apiVersion"`
+	Conditions map[string]string `json:"conditions"`
+}
+
+// RunMetadata fingerprints a test run so the result can be reproduced and audited later
+type RunMetadata struct {
+	RunID       string            `json:"runID"`
+	Test        string            `json:"test"`
+	Started     time.Time         `json:"started"`
+	Cospeck     string            `json:"cospeckVersion"`
+	Runtime     RuntimeInfo       `json:"runtime"`
+	Host        *stats.HostInfo   `json:"host"`
+	Flags       map[string]string `json:"flags"`
+	ConfigHash  string            `json:"configHash"`
+	PodSpecHash string            `json:"podSpecHash"`
+}
+
+// Results is everything a test run produced
+type Results struct {
+	Metadata   *RunMetadata      `json:"metadata"`
+	Runtime    []stats.Metrics   `json:"runtime,omitempty"`
+	Containers []stats.MetricsV2 `json:"containers,omitempty"`
+	Lifecycle  []LifecycleStat   `json:"lifecycle,omitempty"`
+	Phases     []PhaseResult     `json:"phases,omitempty"`
+	Assertions []AssertionResult `json:"assertions,omitempty"`
+	Churn      *ChurnResult      `json:"churn,omitempty"`
+	Leak       *LeakResult       `json:"leak,omitempty"`
+	Exec       *ExecResult       `json:"exec,omitempty"`
+	Density    *DensityResult    `json:"density,omitempty"`
+	NodeBuster *NodeBusterResult `json:"nodeBuster,omitempty"`
+	Logging    *LoggingResult    `json:"logging,omitempty"`
+	Update     *UpdateResult     `json:"update,omitempty"`
+	OOM        *OOMResult        `json:"oom,omitempty"`
+	Storage    *StorageResult    `json:"storage,omitempty"`
+	Streaming  *StreamingResult  `json:"streaming,omitempty"`
+	Resilience *ResilienceResult `json:"resilience,omitempty"`
+	Restart    *RestartResult    `json:"restart,omitempty"`
+}
+
+// newRunMetadata collects the metadata for a run, anything that can't be read is left empty
+func newRunMetadata(ctx context.Context, rt *cri.Runtime, testFlags *TestFlags, test string) *RunMetadata {
+	meta := &RunMetadata{
+		RunID:   newRunID(),
+		Test:    test,
+		Started: time.Now().UTC(),
+		Cospeck: cospeck.Version,
+		Host:    stats.Host(),
+		Flags:   testFlags.CommandLine,
+		Runtime: RuntimeInfo{Conditions: map[string]string{}},
+	}
+
+	// templated pod specs can use the run ID
+	rt.SetRunID(meta.RunID)
+
+	// probed first so a runtime that is down doesn't hold up the run, it fails when the pods are created
+	if err := rt.Probe(ctx, probeTimeout); err != nil {
+		log.WithError(err).Warn("unable to reach the runtime")
+	} else {
+		if version, err := rt.Version(ctx); err != nil {
+			log.WithError(err).Warn("unable to get runtime version")
+		} else {
+			meta.Runtime.Name = version.GetRuntimeName()
+			meta.Runtime.Version = version.GetRuntimeVersion()
+			meta.Runtime.APIVersion = version.GetRuntimeApiVersion()
+		}
+
+		if conditions, err := rt.Status(ctx); err != nil {
+			log.WithError(err).Warn("unable to get runtime status")
+		} else {
+			for _, c := range conditions {
+				status := fmt.Sprint(c.GetStatus())
+				if c.GetReason() != "" {
+					status += " (" + c.GetReason() + ")"
+				}
+				meta.Runtime.Conditions[c.GetType()] = status
+			}
+		}
+	}
+
+	if hash, err := rt.ConfigHash(); err == nil {
+		meta.ConfigHash = hash
+	}
+
+	if spec, err := rt.PodSpec(testFlags.PodConfigFile); err == nil {
+		sum := sha256.Sum256(spec)
+		meta.PodSpecHash = hex.EncodeToString(sum[:])
+	}
+
+	return meta
+}
+
+// newRunID returns a sortable, reasonably unique id for a run
+func newRunID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return time.Now().UTC().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// MetadataWriter writes the run metadata to the terminal
+func MetadataWriter(meta *RunMetadata) {
+	tableWriter := table.NewWriter()
+	tableWriter.SetOutputMirror(os.Stdout)
+	tableWriter.AppendHeader(table.Row{"Run", meta.RunID})
+	tableWriter.AppendRow(table.Row{"Test", meta.Test})
+	tableWriter.AppendRow(table.Row{"Started", meta.Started.Format(time.RFC3339)})
+	tableWriter.AppendRow(table.Row{"Cospeck", meta.Cospeck})
+	tableWriter.AppendRow(table.Row{"Runtime", fmt.Sprintf("%s %s (CRI %s)", meta.Runtime.Name, meta.Runtime.Version, meta.Runtime.APIVersion)})
+	conditions := make([]string, 0, len(meta.Runtime.Conditions))
+	for c := range meta.Runtime.Conditions {
+		conditions = append(conditions, c)
+	}
+	sort.Strings(conditions)
+	for _, c := range conditions {
+		tableWriter.AppendRow(table.Row{"Condition " + c, meta.Runtime.Conditions[c]})
+	}
+	tableWriter.AppendRow(table.Row{"Kernel", meta.Host.KernelVersion})
+	tableWriter.AppendRow(table.Row{"CGroups", meta.Host.CGroupVersion})
+	tableWriter.AppendRow(table.Row{"CPU", fmt.Sprintf("%d x %s", meta.Host.CPUCount, meta.Host.CPUModel)})
+	tableWriter.AppendRow(table.Row{"Memory (MiB)", meta.Host.MemTotal})
+	tableWriter.AppendRow(table.Row{"Config Hash", meta.ConfigHash})
+	tableWriter.AppendRow(table.Row{"Pod Spec Hash", meta.PodSpecHash})
+	flags := make([]string, 0, len(meta.Flags))
+	for f := range meta.Flags {
+		flags = append(flags, f)
+	}
+	sort.Strings(flags)
+	for _, f := range flags {
+		tableWriter.AppendRow(table.Row{"--" + f, meta.Flags[f]})
+	}
+	tableWriter.Render()
+}
+
+// ResultsWriter writes results as json to the results file if one was asked for
+func ResultsWriter(testFlags *TestFlags, results *Results) error {
+	if testFlags.ResultsFile == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(testFlags.ResultsFile, b, 0644)
+}
@@ -3,91 +3,285 @@ package tests
 import (
 	"context"
 	"fmt"
+	"os"
 	"strconv"
+	"sync"
 	"time"
 
-	"github.com/Klaven/cospeck/internal/runtime/cri"
+	"github.com/Klaven/cospeck/internal/logging"
 	"github.com/Klaven/cospeck/internal/stats"
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/limiter"
 )
 
-// NodeBusterResults holds results of running a node-buster test
-type NodeBusterResults struct {
-	Message string
-	Total   int
-	Error   error
+const (
+	// busterWindow is how many of the most recent creates the latency and error rate are taken over
+	busterWindow = 20
+	// busterCheckInterval is how often the memory and pressure conditions are checked
+	busterCheckInterval = time.Second
+)
+
+// NodeBusterLimits are the conditions that stop a nodebuster run, zero turns a condition off.
+// The error rate is always on, at zero the first failed pod trips it, above zero it waits for half a window of creates
+type NodeBusterLimits struct {
+	// MaxCreateLatency is compared to the p90 of the recent creates
+	MaxCreateLatency time.Duration
+	// MaxRuntimeMemory is the runtime cgroups RSS in MiB
+	MaxRuntimeMemory uint64
+	// MinNodeAvailable is the nodes MemAvailable in MiB
+	MinNodeAvailable uint64
+	// MaxMemoryPressure is the memory PSI "some" avg10 in percent
+	MaxMemoryPressure float64
+	// MaxErrorRate is the fraction of the recent creates that failed
+	MaxErrorRate float64
+	MaxPods      int
+	// StopOnAll keeps creating pods until every condition has tripped instead of stopping at the first
+	StopOnAll bool
 }
 
-// NodeBusterTest is a very basic test to try and see how many nodes can run on a machine.
-// DO NOT RUN ON A MACHINE RUNNING ANYTHING
-func NodeBusterTest(testFlags *TestFlags) {
-	fmt.Println("Running tests")
-	sampler, err := stats.NewCGroupsSampler(testFlags.CGroupPath)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
+// BusterCondition is one stop condition and the density reached when it tripped
+type BusterCondition struct {
+	Name    string `json:"name"`
+	Limit   string `json:"limit"`
+	Tripped bool   `json:"tripped"`
+	// Unavailable conditions can't be measured on this host, they never trip and --stop-when all doesn't wait for them
+	Unavailable bool `json:"unavailable,omitempty"`
+	// Pods is how many pods were running when the condition tripped
+	Pods  int    `json:"pods"`
+	Value string `json:"value,omitempty"`
+}
 
-	rt, err := cri.NewCRIRuntime(testFlags.OCIRuntime, 30*time.Second, nil, nil)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
+// NodeBusterResult is the outcome of a nodebuster run
+type NodeBusterResult struct {
+	Conditions []*BusterCondition `json:"conditions"`
+	// Pods is how many pods were running once the pods in flight when the run stopped had finished
+	Pods     int `json:"pods"`
+	Failures int `json:"failures"`
+}
 
-	ctx := context.Background()
+// busterMonitor watches the stop conditions while pods are being created
+type busterMonitor struct {
+	limits  NodeBusterLimits
+	sampler *stats.CGroupsSampler
+	result  *NodeBusterResult
 
-	rt.Clean(ctx)
+	mu       sync.Mutex
+	latency  []time.Duration
+	outcomes []bool
+	stopOnce sync.Once
+	stop     chan struct{}
+}
 
-	initTotal, _ := sampler.Sample("init")
-	fmt.Println("Total CPU: ", initTotal.CPU)
-	fmt.Println("Total Memory: ", initTotal.Mem)
+func newBusterMonitor(limits NodeBusterLimits, sampler *stats.CGroupsSampler) *busterMonitor {
+	m := &busterMonitor{limits: limits, sampler: sampler, result: &NodeBusterResult{}, stop: make(chan struct{})}
+	add := func(name, limit string, enabled bool) {
+		if enabled {
+			m.result.Conditions = append(m.result.Conditions, &BusterCondition{Name: name, Limit: limit})
+		}
+	}
+	add("create latency", "p90 > "+limits.MaxCreateLatency.String(), limits.MaxCreateLatency > 0)
+	add("runtime memory", fmt.Sprintf("> %d MiB", limits.MaxRuntimeMemory), limits.MaxRuntimeMemory > 0)
+	add("node available", fmt.Sprintf("< %d MiB", limits.MinNodeAvailable), limits.MinNodeAvailable > 0)
+	add("memory pressure", fmt.Sprintf("> %.1f%%", limits.MaxMemoryPressure), limits.MaxMemoryPressure > 0)
+	add("error rate", fmt.Sprintf("> %.0f%%", limits.MaxErrorRate*100), true)
+	add("pods", fmt.Sprintf(">= %d", limits.MaxPods), limits.MaxPods > 0)
 
-	fmt.Println("Starting Pods")
+	if limits.MaxMemoryPressure > 0 {
+		if _, err := stats.MemoryPressure(); err != nil {
+			log.WithError(err).Warn("memory pressure is not available, that condition will never trip")
+			m.unavailable("memory pressure")
+		}
+	}
+	if limits.MaxRuntimeMemory > 0 {
+		if _, err := sampler.Memory(); err != nil {
+			log.WithError(err).Warn("runtime memory is not available, that condition will never trip")
+			m.unavailable("runtime memory")
+		}
+	}
+	return m
+}
 
-	l := limiter.New(testFlags.Threads)
+// unavailable marks a condition that can't be measured on this host
+func (m *busterMonitor) unavailable(name string) {
+	for _, c := range m.result.Conditions {
+		if c.Name == name {
+			c.Unavailable = true
+		}
+	}
+}
+
+// created records a create and checks the conditions that depend on it
+func (m *busterMonitor) created(ctx context.Context, latency time.Duration, err error) {
+	m.mu.Lock()
+	m.outcomes = append(m.outcomes, err == nil)
+	if len(m.outcomes) > busterWindow {
+		m.outcomes = m.outcomes[1:]
+	}
+	if err == nil {
+		m.latency = append(m.latency, latency)
+		if len(m.latency) > busterWindow {
+			m.latency = m.latency[1:]
+		}
+	} else {
+		m.result.Failures++
+	}
 
-	errorChan := make(chan *NodeBusterResults)
+	failed := 0
+	for _, ok := range m.outcomes {
+		if !ok {
+			failed++
+		}
+	}
+	rate := float64(failed) / float64(len(m.outcomes))
+	outcomes := len(m.outcomes)
+	p90 := stats.Percentile(m.latency, 90)
+	measured := len(m.latency)
+	m.mu.Unlock()
 
-	for i := 0; ; /*don't stop*/ i++ {
+	// a rate over a few creates says little, one early failure would be 100%
+	if failed > 0 && rate > m.limits.MaxErrorRate && (m.limits.MaxErrorRate == 0 || outcomes >= busterWindow/2) {
+		m.trip(ctx, "error rate", fmt.Sprintf("%.0f%%", rate*100))
+	}
+	if m.limits.MaxCreateLatency > 0 && measured >= busterWindow/2 && p90 > m.limits.MaxCreateLatency {
+		m.trip(ctx, "create latency", p90.Round(time.Millisecond).String())
+	}
+	if running := len(runningPods()); m.limits.MaxPods > 0 && running >= m.limits.MaxPods {
+		m.trip(ctx, "pods", strconv.Itoa(running))
+	}
+}
 
-		var podErr *NodeBusterResults
+// watch checks the memory and pressure conditions until the run stops
+func (m *busterMonitor) watch(ctx context.Context) {
+	ticker := time.NewTicker(busterCheckInterval)
+	defer ticker.Stop()
+	for {
 		select {
-		case podErr = <-errorChan:
-			fmt.Printf("This node can run %d of this pod before running into errors/n", podErr.Total)
-			break
-		default:
+		case <-m.stop:
+			return
+		case <-ticker.C:
 		}
 
-		if podErr != nil {
-			break
+		if m.limits.MaxRuntimeMemory > 0 {
+			if mem, err := m.sampler.Memory(); err == nil && mem > m.limits.MaxRuntimeMemory {
+				m.trip(ctx, "runtime memory", fmt.Sprintf("%d MiB", mem))
+			}
 		}
-
-		fmt.Println("starting pod number: ", i)
-		runNumberAsString := strconv.Itoa(i)
-		l.Begin()
-		go func(i int, ctx context.Context, runtime *cri.Runtime, podConfigFile string, uid string, finished *limiter.Limiter) {
-			err := createPod(ctx, rt, podConfigFile, runNumberAsString, l)
-			if err != nil {
-				errorChan <- &NodeBusterResults{
-					Message: "It's Finished",
-					Total:   i,
-					Error:   err,
-				}
+		if m.limits.MinNodeAvailable > 0 {
+			if _, available := stats.NodeMemory(); available < m.limits.MinNodeAvailable {
+				m.trip(ctx, "node available", fmt.Sprintf("%d MiB", available))
+			}
+		}
+		if m.limits.MaxMemoryPressure > 0 {
+			if psi, err := stats.MemoryPressure(); err == nil && psi > m.limits.MaxMemoryPressure {
+				m.trip(ctx, "memory pressure", fmt.Sprintf("%.1f%%", psi))
 			}
-		}(i, ctx, rt, testFlags.PodConfigFile, runNumberAsString, l)
+		}
+	}
+}
+
+// trip records the density a condition first tripped at and stops the run if the policy says so
+func (m *busterMonitor) trip(ctx context.Context, name, value string) {
+	pods := len(runningPods())
+
+	m.mu.Lock()
+	all := true
+	for _, c := range m.result.Conditions {
+		if c.Name == name && !c.Tripped {
+			c.Tripped, c.Pods, c.Value = true, pods, value
+			logging.FromContext(ctx).WithFields(log.Fields{"condition": name, "value": value, "pods": pods}).Warn("stop condition tripped")
+		}
+		all = all && (c.Tripped || c.Unavailable)
+	}
+	m.mu.Unlock()
+
+	if !m.limits.StopOnAll || all {
+		m.stopOnce.Do(func() { close(m.stop) })
+	}
+}
+
+func (m *busterMonitor) stopped() bool {
+	select {
+	case <-m.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// NodeBusterTest creates pods until a stop condition trips to see how many pods a node can run.
+// Pods in flight when it stops are waited for and counted. DO NOT RUN ON A MACHINE RUNNING ANYTHING
+func NodeBusterTest(testFlags *TestFlags, limits NodeBusterLimits) error {
+	if limits.MaxErrorRate < 0 || limits.MaxErrorRate >= 1 {
+		return fmt.Errorf("the max error rate is a fraction of the recent creates, it must be at least 0 and less than 1")
 	}
 
-	println("Finished Starting Pods")
+	log.Info("Running tests")
+	run, err := startRun(testFlags, "nodebuster")
+	if err != nil {
+		return err
+	}
+	defer run.close()
+	rt, ctx, sampler := run.rt, run.ctx, run.sampler
 
+	initTotal, err := sampler.Sample("init")
 	if err != nil {
-		fmt.Println("Failed to get cgroup sampler")
+		tracker.Stop()
+		return err
 	}
+	logging.FromContext(ctx).WithFields(log.Fields{"cpu": initTotal.CPU, "memory": initTotal.Mem}).Info("Runtime usage before starting")
+
+	logging.FromContext(ctx).WithField(logging.Phase, "create").Info("Starting pods")
+
+	l := limiter.New(testFlags.Threads)
+
+	monitor := newBusterMonitor(limits, sampler)
+	go monitor.watch(ctx)
 
-	fmt.Println("")
-	fmt.Println("Stopping Pods")
-	for _, p := range pods {
+	var wg sync.WaitGroup
+	for i := 0; !monitor.stopped(); i++ {
 		l.Begin()
-		stopPod(ctx, rt, &p, l)
+		if monitor.stopped() {
+			l.End()
+			break
+		}
+		wg.Add(1)
+		go func(uid string) {
+			defer wg.Done()
+			latency, err := createPod(ctx, rt, testFlags.PodConfigFile, uid, l)
+			monitor.created(ctx, latency, err)
+		}(strconv.Itoa(i))
 	}
 
+	// pods still being created are waited for so they are counted and cleaned up
+	wg.Wait()
+	monitor.result.Pods = len(runningPods())
+	logging.FromContext(ctx).WithField(logging.Phase, "create").Infof("Finished starting pods, %d running", monitor.result.Pods)
+
+	logging.FromContext(ctx).WithField(logging.Phase, "stop").Info("Stopping pods")
+	stopPods(ctx, rt, testFlags.Threads)
+	removeStoppedPods(ctx, rt)
+	tracker.Stop()
+
+	finishRun(run, &Results{NodeBuster: monitor.result},
+		resultSection{"Node Buster", func() { NodeBusterWriter(monitor.result) }})
+	return nil
+}
+
+// NodeBusterWriter writes the density reached under each stop condition to the terminal
+func NodeBusterWriter(result *NodeBusterResult) {
+	tableWriter := table.NewWriter()
+	tableWriter.SetOutputMirror(os.Stdout)
+	tableWriter.AppendHeader(table.Row{"Condition", "Limit", "Pods", "Value"})
+	for _, c := range result.Conditions {
+		if c.Tripped {
+			tableWriter.AppendRow(table.Row{c.Name, c.Limit, c.Pods, c.Value})
+		} else if c.Unavailable {
+			tableWriter.AppendRow(table.Row{c.Name, c.Limit, "unavailable", ""})
+		} else {
+			tableWriter.AppendRow(table.Row{c.Name, c.Limit, "not reached", ""})
+		}
+	}
+	tableWriter.AppendFooter(table.Row{"running at stop", "", result.Pods, fmt.Sprintf("%d failed", result.Failures)})
+	tableWriter.Render()
 }
@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestBusterStopOnAll(t *testing.T) {
+	m := &busterMonitor{
+		limits: NodeBusterLimits{StopOnAll: true},
+		result: &NodeBusterResult{Conditions: []*BusterCondition{
+			{Name: "error rate"}, {Name: "memory pressure"}, {Name: "pods"},
+		}},
+		stop: make(chan struct{}),
+	}
+	m.unavailable("memory pressure")
+
+	m.trip(context.Background(), "error rate", "50%")
+	if m.stopped() {
+		t.Errorf("Expected the run to go on until every condition tripped")
+	}
+	m.trip(context.Background(), "pods", "10")
+	if !m.stopped() {
+		t.Errorf("Expected an unavailable condition not to hold up the run")
+	}
+	if c := m.result.Conditions[1]; c.Tripped {
+		t.Errorf("Expected an unavailable condition never to trip found %+v", c)
+	}
+}
+
+func TestNodeBusterErrorRate(t *testing.T) {
+	for _, rate := range []float64{-0.1, 1, 2} {
+		if err := NodeBusterTest(&TestFlags{}, NodeBusterLimits{MaxErrorRate: rate}); err == nil {
+			t.Errorf("Expected a max error rate of %v to be rejected", rate)
+		}
+	}
+}
+
+func TestBusterErrorRateWindow(t *testing.T) {
+	newMonitor := func(rate float64) *busterMonitor {
+		return &busterMonitor{
+			limits: NodeBusterLimits{MaxErrorRate: rate},
+			result: &NodeBusterResult{Conditions: []*BusterCondition{{Name: "error rate"}}},
+			stop:   make(chan struct{}),
+		}
+	}
+	failed := errors.New("failed")
+
+	m := newMonitor(0.5)
+	m.created(context.Background(), 0, failed)
+	if m.stopped() {
+		t.Errorf("Expected one failure on the first create not to trip a 50%% error rate")
+	}
+	for i := 1; i < busterWindow/2; i++ {
+		m.created(context.Background(), 0, failed)
+	}
+	if !m.stopped() {
+		t.Errorf("Expected half a window of failures to trip a 50%% error rate")
+	}
+
+	m = newMonitor(0)
+	m.created(context.Background(), 0, failed)
+	if !m.stopped() {
+		t.Errorf("Expected the first failure to trip a zero error rate")
+	}
+}
@@ -0,0 +1,309 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	criapi "github.com/Klaven/cospeck/cri"
+	"github.com/Klaven/cospeck/internal/logging"
+	"github.com/Klaven/cospeck/internal/runtime"
+	"github.com/Klaven/cospeck/internal/runtime/cri"
+	"github.com/Klaven/cospeck/internal/stats"
+	"github.com/Klaven/cospeck/internal/trace"
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/limiter"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// oomPoll is how often container status is read while waiting for the kill
+	oomPoll = 20 * time.Millisecond
+	// oomEventPoll is how often the containers cgroup oom events are read, it has to catch them before the cgroup is removed
+	oomEventPoll = 2 * time.Millisecond
+)
+
+// oomReason is what the kubelet and runtimes report for a container the kernel OOM killed
+const oomReason = "OOMKilled"
+
+// OOMResult is the outcome of an OOM test. Killed is from the container starting (when it begins allocating)
+// to when the runtime says it finished, Reported is to when ContainerStatus first showed it exited
+type OOMResult struct {
+	Containers int    `json:"containers"`
+	Allocate   string `json:"allocate"`
+	Limit      string `json:"limit"`
+	// OOMKilled containers exited with reason OOMKilled, Exited ones for some other reason and Survived ones hadn't exited by the timeout
+	OOMKilled int `json:"oomKilled"`
+	Exited    int `json:"exited"`
+	Survived  int `json:"survived"`
+	Failures  int `json:"failures"`
+	// ExitCodes and Reasons count what the exited containers reported
+	ExitCodes map[int32]int  `json:"exitCodes"`
+	Reasons   map[string]int `json:"reasons"`
+	// OOMEvents is the oom_kill count of the containers cgroups, Unread is containers whose cgroup was gone before it showed a kill
+	OOMEvents int           `json:"oomEvents"`
+	Unread    int           `json:"unread"`
+	Killed    LifecycleStat `json:"killed"`
+	Reported  LifecycleStat `json:"reported"`
+	// ReportLag is from the runtimes finish time to the status showing it
+	ReportLag LifecycleStat `json:"reportLag"`
+}
+
+// oomOutcome is what happened to one container
+type oomOutcome struct {
+	status           *criapi.ContainerStatus
+	killed, reported time.Duration
+	oomKills         int
+	read             bool
+	failed           bool
+}
+
+// OOMTest runs the memory workload allocating more than its memory limit, so every container should be OOM killed.
+// It reports how long the kill and the runtime noticing it took, the exit codes and reasons, and the oom kills in the cgroups
+func OOMTest(testFlags *TestFlags, totalPods int, allocate, limit string, timeout time.Duration) error {
+	if totalPods < 1 || timeout <= 0 {
+		return fmt.Errorf("pods and timeout must be more than zero")
+	}
+	if err := requireWorkload(testFlags, "oom", "memory"); err != nil {
+		return err
+	}
+	allocateSize, err := resource.ParseQuantity(allocate)
+	if err != nil {
+		return fmt.Errorf("allocate %q: %v", allocate, err)
+	}
+	limitSize, err := resource.ParseQuantity(limit)
+	if err != nil {
+		return fmt.Errorf("limit %q: %v", limit, err)
+	}
+	if allocateSize.Cmp(limitSize) <= 0 {
+		return fmt.Errorf("allocate (%s) must be more than the limit (%s) for the containers to be OOM killed", allocate, limit)
+	}
+	testFlags.WorkloadParams.Memory = allocate
+	testFlags.WorkloadParams.MemoryLimit = limit
+
+	run, err := startRun(testFlags, "oom")
+	if err != nil {
+		return err
+	}
+	defer run.close()
+	rt, ctx := run.rt, run.ctx
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		outcomes []oomOutcome
+	)
+	logging.FromContext(ctx).WithField(logging.Phase, "oom").Infof("Starting %d pods allocating %s over a %s limit", totalPods, allocate, limit)
+	l := limiter.New(testFlags.Threads)
+	for i := 0; i < totalPods; i++ {
+		l.Begin()
+		wg.Add(1)
+		go func(uid string) {
+			defer wg.Done()
+			defer l.End()
+			o := runOOMPod(ctx, rt, uid, timeout)
+			mu.Lock()
+			outcomes = append(outcomes, o...)
+			mu.Unlock()
+		}(strconv.Itoa(i))
+	}
+	wg.Wait()
+
+	logging.FromContext(ctx).WithField(logging.Phase, "stop").Info("Stopping pods")
+	stopPods(ctx, rt, testFlags.Threads)
+	removeStoppedPods(ctx, rt)
+	tracker.Stop()
+
+	result := oomResult(outcomes)
+	result.Allocate, result.Limit = allocate, limit
+
+	finishRun(run, &Results{OOM: result},
+		resultSection{"OOM", func() {
+			LifecycleWriter([]LifecycleStat{result.Killed, result.Reported, result.ReportLag})
+			OOMWriter(result)
+		}})
+
+	if result.OOMKilled != result.Containers {
+		return fmt.Errorf("%d of %d containers were not reported as %s", result.Containers-result.OOMKilled, result.Containers, oomReason)
+	}
+	return nil
+}
+
+// runOOMPod creates a pod and starts its containers, then waits for each of them to exit.
+// It doesn't use createPod as that waits for the containers to be running, which they may never be
+func runOOMPod(ctx context.Context, rt *cri.Runtime, uid string, timeout time.Duration) []oomOutcome {
+	ctx, span := trace.Start(ctx, "pod")
+	defer span.Finish()
+	span.SetAttribute("pod.index", uid)
+	ctx = logging.WithFields(ctx, log.Fields{logging.PodIndex: uid, logging.Phase: "oom"})
+
+	pod, err := rt.CreatePodAndContainerFromSpec(ctx, "", uid)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).Error("unable to create pod")
+		tracker.Failed()
+		span.SetError(err)
+		return []oomOutcome{{failed: true}}
+	}
+	ctx = logging.WithField(ctx, logging.PodID, pod.PodID())
+	tracker.Created()
+	mutex.Lock()
+	pods = append(pods, testPod{Index: uid, Pod: &pod, Span: span})
+	mutex.Unlock()
+
+	outcomes := []oomOutcome{}
+	for _, c := range pod.Containers() {
+		cctx := logging.WithField(ctx, "container", c.Name())
+		if _, err := rt.Run(cctx, c); err != nil {
+			logging.FromContext(cctx).WithError(err).Error("unable to start container")
+			tracker.Failed()
+			outcomes = append(outcomes, oomOutcome{failed: true})
+			continue
+		}
+		outcomes = append(outcomes, waitOOM(cctx, rt, c, timeout))
+	}
+	return outcomes
+}
+
+// waitOOM polls a containers status until it exits or timeout passes. Its cgroups oom kills are watched alongside, more
+// often than the status, as the runtime usually removes the cgroup when the container exits. Only the containers own
+// cgroup is read, a container whose cgroup went before it showed a kill is left unread
+func waitOOM(ctx context.Context, rt *cri.Runtime, c runtime.Container, timeout time.Duration) oomOutcome {
+	var o oomOutcome
+	stop := make(chan struct{})
+	kills := make(chan int, 1)
+	go func() { kills <- watchOOMKills(c.ContainerID(), stop) }()
+	finish := func() {
+		close(stop)
+		o.oomKills = <-kills
+		o.read = o.oomKills > 0
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		status, err := rt.ContainerStatus(ctx, c)
+		if err != nil {
+			logging.FromContext(ctx).WithError(err).Error("unable to get container status")
+			finish()
+			o.failed = true
+			return o
+		}
+		if status.GetState() == criapi.ContainerState_CONTAINER_EXITED {
+			now := time.Now()
+			o.status = status
+			started := time.Unix(0, status.GetStartedAt())
+			o.reported = now.Sub(started)
+			if status.GetFinishedAt() > 0 {
+				o.killed = time.Unix(0, status.GetFinishedAt()).Sub(started)
+			}
+			finish()
+			return o
+		}
+		time.Sleep(oomPoll)
+	}
+	logging.FromContext(ctx).Warn("container was still running at the timeout")
+	finish()
+	return o
+}
+
+// watchOOMKills reads the oom_kill count of a containers cgroup every oomEventPoll once it is found, until the cgroup is gone or stop is closed,
+// and returns the highest count it saw
+func watchOOMKills(containerID string, stop <-chan struct{}) int {
+	cgroup, seen := "", 0
+	for {
+		if cgroup == "" {
+			cgroup, _ = stats.ContainerCGroup(containerID)
+		}
+		if cgroup != "" {
+			kills, err := stats.OOMKills(cgroup)
+			if err != nil {
+				// the cgroup has been removed, the count can't go up any more
+				return seen
+			}
+			if kills > seen {
+				seen = kills
+			}
+		}
+
+		// finding the cgroup walks the hierarchy, so that is only tried as often as the status is read
+		poll := oomEventPoll
+		if cgroup == "" {
+			poll = oomPoll
+		}
+		select {
+		case <-stop:
+			return seen
+		case <-time.After(poll):
+		}
+	}
+}
+
+// oomResult counts the outcomes
+func oomResult(outcomes []oomOutcome) *OOMResult {
+	result := &OOMResult{ExitCodes: map[int32]int{}, Reasons: map[string]int{}}
+	var killed, reported, lag []time.Duration
+	for _, o := range outcomes {
+		result.Containers++
+		switch {
+		case o.failed:
+			result.Failures++
+			continue
+		case o.status == nil:
+			result.Survived++
+			continue
+		case o.status.GetReason() == oomReason:
+			result.OOMKilled++
+		default:
+			result.Exited++
+		}
+		result.ExitCodes[o.status.GetExitCode()]++
+		result.Reasons[o.status.GetReason()]++
+		result.OOMEvents += o.oomKills
+		if !o.read {
+			result.Unread++
+		}
+		killed = append(killed, o.killed)
+		reported = append(reported, o.reported)
+		if o.killed > 0 {
+			lag = append(lag, o.reported-o.killed)
+		}
+	}
+	result.Killed = newLifecycleStat("killed", killed)
+	result.Reported = newLifecycleStat("reported", reported)
+	result.ReportLag = newLifecycleStat("report lag", lag)
+	return result
+}
+
+// OOMWriter writes how the containers ended to the terminal
+func OOMWriter(result *OOMResult) {
+	exitCodes := map[string]int{}
+	for code, count := range result.ExitCodes {
+		exitCodes[strconv.Itoa(int(code))] = count
+	}
+
+	tableWriter := table.NewWriter()
+	tableWriter.SetOutputMirror(os.Stdout)
+	tableWriter.AppendHeader(table.Row{"Containers", oomReason, "Other Exit", "Survived", "Failed", "OOM Events (unread)", "Exit Codes", "Reasons"})
+	tableWriter.AppendRow(table.Row{result.Containers, result.OOMKilled, result.Exited, result.Survived, result.Failures,
+		fmt.Sprintf("%d (%d)", result.OOMEvents, result.Unread), countsString(exitCodes), countsString(result.Reasons)})
+	tableWriter.AppendFooter(table.Row{"allocate", result.Allocate, "limit", result.Limit, "", "", "", ""})
+	tableWriter.Render()
+}
+
+// countsString formats counts as "key: count" sorted by key
+func countsString(counts map[string]int) string {
+	items := []string{}
+	for k, v := range counts {
+		if k == "" {
+			k = "(none)"
+		}
+		items = append(items, fmt.Sprintf("%s: %d", k, v))
+	}
+	sort.Strings(items)
+	return strings.Join(items, ", ")
+}
@@ -0,0 +1,46 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	criapi "github.com/Klaven/cospeck/cri"
+)
+
+func TestOOMResult(t *testing.T) {
+	outcomes := []oomOutcome{
+		{status: &criapi.ContainerStatus{Reason: oomReason, ExitCode: 137}, killed: time.Second, reported: 1200 * time.Millisecond, oomKills: 1, read: true},
+		{status: &criapi.ContainerStatus{Reason: oomReason, ExitCode: 137}, killed: 2 * time.Second, reported: 2100 * time.Millisecond},
+		{status: &criapi.ContainerStatus{Reason: "Error", ExitCode: 1}, reported: 500 * time.Millisecond, read: true},
+		{failed: true},
+		{},
+	}
+	result := oomResult(outcomes)
+
+	tests := []struct {
+		name      string
+		got, want int
+	}{
+		{"containers", result.Containers, 5},
+		{"oom killed", result.OOMKilled, 2},
+		{"exited", result.Exited, 1},
+		{"survived", result.Survived, 1},
+		{"failures", result.Failures, 1},
+		{"oom events", result.OOMEvents, 1},
+		{"unread", result.Unread, 1},
+		{"exit code 137", result.ExitCodes[137], 2},
+		{"exit code 1", result.ExitCodes[1], 1},
+		{"oom reason", result.Reasons[oomReason], 2},
+		{"killed count", result.Killed.Count, 2},
+		// the container without a finish time has no kill time or lag
+		{"report lag count", result.ReportLag.Count, 2},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: expected %d found %d", tt.name, tt.want, tt.got)
+		}
+	}
+	if result.ReportLag.P99 != 200*time.Millisecond {
+		t.Errorf("Expected a p99 report lag of 200ms found %s", result.ReportLag.P99)
+	}
+}
@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Klaven/cospeck/internal/logging"
+	"github.com/Klaven/cospeck/internal/runtime/cri"
+	"github.com/tidwall/limiter"
+)
+
+// inter-arrival distributions of the open loop mode
+const (
+	ArrivalConstant = "constant"
+	ArrivalPoisson  = "poisson"
+)
+
+// openLoopResult is what an open loop run measured. Latency is from when each pod was meant to start,
+// so time spent queued behind a slow runtime is counted instead of hidden (coordinated omission).
+// Failed creates are in Latency too, up to when they failed, and on their own in Failed
+type openLoopResult struct {
+	Latency []time.Duration
+	Queue   []time.Duration
+	Failed  []time.Duration
+	// Lag is how far behind schedule each pod was started
+	Lag []time.Duration
+}
+
+// rows are the open loop rows of the pod lifecycle table
+func (r openLoopResult) rows() []LifecycleStat {
+	return []LifecycleStat{
+		newLifecycleStat("create (from intended start)", r.Latency),
+		newLifecycleStat("create failed (from intended start)", r.Failed),
+		newLifecycleStat("queued", r.Queue),
+		newLifecycleStat("start lag", r.Lag),
+	}
+}
+
+// arrivalSeed seeds the poisson arrivals from the run id, so a run's schedule can be generated again
+func arrivalSeed(runID string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(runID))
+	return int64(h.Sum64())
+}
+
+// arrivals returns when each of count pods should start, relative to the start of the run
+func arrivals(count int, rate float64, arrival string, rng *rand.Rand) ([]time.Duration, error) {
+	if rate <= 0 {
+		return nil, fmt.Errorf("arrival rate must be more than zero")
+	}
+
+	at := make([]time.Duration, 0, count)
+	var next float64
+	for i := 0; i < count; i++ {
+		at = append(at, time.Duration(next*float64(time.Second)))
+		switch arrival {
+		case ArrivalConstant:
+			next += 1 / rate
+		case ArrivalPoisson:
+			next += rng.ExpFloat64() / rate
+		default:
+			return nil, fmt.Errorf("unknown arrival %q, expected %s or %s", arrival, ArrivalConstant, ArrivalPoisson)
+		}
+	}
+	return at, nil
+}
+
+// openLoop starts count pods, numbered from first, at their scheduled times whether or not earlier pods have finished.
+// maxInFlight caps how many pods are created at once, 0 is no cap, pods waiting for a slot are still late
+func openLoop(ctx context.Context, rt *cri.Runtime, podConfigFile string, first, count int, schedule []time.Duration, maxInFlight int) openLoopResult {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		result openLoopResult
+	)
+
+	if maxInFlight <= 0 {
+		maxInFlight = count
+	}
+	l := limiter.New(maxInFlight)
+
+	var maxLag time.Duration
+	start := time.Now()
+	for i := 0; i < count; i++ {
+		intended := start.Add(schedule[i])
+		time.Sleep(time.Until(intended))
+		lag := time.Since(intended)
+		if lag > maxLag {
+			maxLag = lag
+		}
+		result.Lag = append(result.Lag, lag)
+
+		wg.Add(1)
+		go func(uid string, intended time.Time) {
+			defer wg.Done()
+			l.Begin()
+			queued := time.Since(intended)
+			_, err := createPod(ctx, rt, podConfigFile, uid, l)
+			latency := time.Since(intended)
+
+			mu.Lock()
+			defer mu.Unlock()
+			result.Latency = append(result.Latency, latency)
+			result.Queue = append(result.Queue, queued)
+			if err != nil {
+				result.Failed = append(result.Failed, latency)
+			}
+		}(strconv.Itoa(first+i), intended)
+	}
+	wg.Wait()
+
+	if maxLag > time.Second {
+		logging.FromContext(ctx).WithField("lag", maxLag).Warn("pods were started late, the arrival rate is more than cospeck can generate")
+	}
+	return result
+}
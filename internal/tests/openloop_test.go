@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestArrivals(t *testing.T) {
+	tests := []struct {
+		name    string
+		count   int
+		rate    float64
+		arrival string
+		want    []time.Duration
+		err     bool
+	}{
+		{"constant", 4, 2, ArrivalConstant, []time.Duration{0, 500 * time.Millisecond, time.Second, 1500 * time.Millisecond}, false},
+		{"none", 0, 2, ArrivalConstant, []time.Duration{}, false},
+		{"zero rate", 4, 0, ArrivalConstant, nil, true},
+		{"unknown arrival", 4, 2, "bursty", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := arrivals(tt.count, tt.rate, tt.arrival, rand.New(rand.NewSource(1)))
+		if (err != nil) != tt.err {
+			t.Errorf("%s: expected error %v found %v", tt.name, tt.err, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: expected %v found %v", tt.name, tt.want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: expected %v found %v", tt.name, tt.want, got)
+				break
+			}
+		}
+	}
+}
+
+func TestPoissonArrivals(t *testing.T) {
+	const count, rate = 10000, 50.0
+	got, err := arrivals(count, rate, ArrivalPoisson, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatalf("Error scheduling arrivals: %s", err)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i] < got[i-1] {
+			t.Fatalf("Expected arrivals in order, %s came after %s", got[i], got[i-1])
+		}
+	}
+	// the mean gap should be close to 1/rate
+	mean := got[len(got)-1].Seconds() / float64(count-1)
+	if mean < 0.95/rate || mean > 1.05/rate {
+		t.Errorf("Expected a mean gap of about %v found %v", 1/rate, mean)
+	}
+
+	again, _ := arrivals(count, rate, ArrivalPoisson, rand.New(rand.NewSource(1)))
+	if again[count-1] != got[count-1] {
+		t.Errorf("Expected the same seed to give the same schedule")
+	}
+}
+
+func TestArrivalSeed(t *testing.T) {
+	schedule := func(runID string) []time.Duration {
+		at, _ := arrivals(5, 2, ArrivalPoisson, rand.New(rand.NewSource(arrivalSeed(runID))))
+		return at
+	}
+	a, b, c := schedule("20260101-000000-abcd"), schedule("20260101-000000-abcd"), schedule("20260101-000000-abce")
+	for i := range a {
+		if a[i] != b[i] {
+			t.Errorf("Expected the same run id to give the same schedule found %v and %v", a, b)
+			break
+		}
+	}
+	same := true
+	for i := range a {
+		same = same && a[i] == c[i]
+	}
+	if same {
+		t.Errorf("Expected different run ids to give different schedules found %v twice", a)
+	}
+}
+
+func TestOpenLoopRows(t *testing.T) {
+	result := openLoopResult{
+		Latency: []time.Duration{time.Second, 2 * time.Second, 3 * time.Second},
+		Queue:   []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond},
+		Failed:  []time.Duration{3 * time.Second},
+		Lag:     []time.Duration{time.Millisecond, 5 * time.Millisecond, 2 * time.Millisecond},
+	}
+	rows := result.rows()
+	counts := map[string]int{}
+	for _, r := range rows {
+		counts[r.Name] = r.Count
+	}
+	if counts["create (from intended start)"] != 3 {
+		t.Errorf("Expected failed creates in the create latency found %d", counts["create (from intended start)"])
+	}
+	if counts["create failed (from intended start)"] != 1 {
+		t.Errorf("Expected 1 failed create found %d", counts["create failed (from intended start)"])
+	}
+	if rows[3].Name != "start lag" || rows[3].Max != 5*time.Millisecond {
+		t.Errorf("Expected a start lag row with max 5ms found %+v", rows[3])
+	}
+}
@@ -0,0 +1,125 @@
+package tests
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Klaven/cospeck/internal/runtime/cri"
+	"github.com/tidwall/limiter"
+)
+
+// createPods creates count pods, numbered from first, threads at a time and waits for all of them.
+// It returns the creation latency of every pod that started and how many failed
+func createPods(ctx context.Context, rt *cri.Runtime, podConfigFile string, first, count, threads int) ([]time.Duration, int) {
+	var (
+		wg        sync.WaitGroup
+		resultsMu sync.Mutex
+		latencies []time.Duration
+		failures  int
+	)
+
+	l := limiter.New(threads)
+	for i := first; i < first+count; i++ {
+		l.Begin()
+		wg.Add(1)
+		go func(uid string) {
+			defer wg.Done()
+			latency, err := createPod(ctx, rt, podConfigFile, uid, l)
+			resultsMu.Lock()
+			defer resultsMu.Unlock()
+			if err != nil {
+				failures++
+				return
+			}
+			latencies = append(latencies, latency)
+		}(strconv.Itoa(i))
+	}
+	wg.Wait()
+
+	return latencies, failures
+}
+
+// stopPods stops every running pod, threads at a time, and returns how long each stop took.
+// The pods are stopped from a snapshot and marked stopped under the lock by id, as the list may change meanwhile
+func stopPods(ctx context.Context, rt *cri.Runtime, threads int) []time.Duration {
+	running := runningPods()
+
+	var wg sync.WaitGroup
+	l := limiter.New(threads)
+	for i := range running {
+		l.Begin()
+		wg.Add(1)
+		go func(p *testPod) {
+			defer wg.Done()
+			stopPod(ctx, rt, p, l)
+			markStopped(p)
+		}(&running[i])
+	}
+	wg.Wait()
+
+	durations := make([]time.Duration, 0, len(running))
+	for _, p := range running {
+		durations = append(durations, p.DestructionTime)
+	}
+	return durations
+}
+
+// markStopped records a stopped pod in the list of pods, if it is still there
+func markStopped(stopped *testPod) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for i := range pods {
+		if pods[i].Pod == stopped.Pod {
+			pods[i].Stopped = true
+			pods[i].DestructionTime = stopped.DestructionTime
+			return
+		}
+	}
+}
+
+// removeStoppedPods removes every stopped pod and forgets about it
+func removeStoppedPods(ctx context.Context, rt *cri.Runtime) int {
+	mutex.Lock()
+	var stopped, kept []testPod
+	for _, p := range pods {
+		if p.Stopped {
+			stopped = append(stopped, p)
+		} else {
+			kept = append(kept, p)
+		}
+	}
+	pods = kept
+	mutex.Unlock()
+
+	for i := range stopped {
+		removePod(ctx, rt, &stopped[i])
+	}
+	return len(stopped)
+}
+
+// takeOldestPod removes the oldest pod from the list of pods and returns it
+func takeOldestPod() (testPod, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(pods) == 0 {
+		return testPod{}, false
+	}
+	p := pods[0]
+	pods = pods[1:]
+	return p, true
+}
+
+// runningPods returns a snapshot of the pods that have not been stopped
+func runningPods() []testPod {
+	mutex.Lock()
+	defer mutex.Unlock()
+	running := []testPod{}
+	for _, p := range pods {
+		if !p.Stopped {
+			running = append(running, p)
+		}
+	}
+	return running
+}
@@ -0,0 +1,189 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	criapi "github.com/Klaven/cospeck/cri"
+	"github.com/Klaven/cospeck/internal/logging"
+	"github.com/Klaven/cospeck/internal/runtime/cri"
+	"github.com/jedib0t/go-pretty/table"
+)
+
+// probeTimeout is how long a single probe of the runtime may take before it counts as unavailable
+const probeTimeout = time.Second
+
+// ResilienceResult is the outcome of a resilience test
+type ResilienceResult struct {
+	Pods           int    `json:"pods"`
+	RestartCommand string `json:"restartCommand,omitempty"`
+	// Restarted is whether the runtime was seen unavailable and came back within the test
+	Restarted bool `json:"restarted"`
+	// Downtime is from the first probe of the runtime that failed to the next one that succeeded
+	Downtime     time.Duration `json:"downtime"`
+	Probes       int           `json:"probes"`
+	FailedProbes int           `json:"failedProbes"`
+	// Outages are the times the grpc connection to the runtime dropped
+	Outages []cri.Outage `json:"outages"`
+	// Survived pods were listed by ListPodSandbox after the restart, Ready ones were still SANDBOX_READY
+	Survived    int      `json:"survived"`
+	Ready       int      `json:"ready"`
+	MissingPods []string `json:"missingPods,omitempty"`
+	// Containers of the surviving pods, and how many of them were still running
+	Containers int `json:"containers"`
+	Running    int `json:"running"`
+}
+
+// ResilienceTest starts pods and then probes the runtime every probeInterval, waiting up to duration for it to become
+// unavailable and come back, either by running restartCommand or by someone restarting it by hand. It reports how long
+// the runtime was unavailable and whether the pods created before the restart are still listed by ListPodSandbox
+func ResilienceTest(testFlags *TestFlags, totalPods int, duration, probeInterval time.Duration, restartCommand string) error {
+	if totalPods < 1 || duration <= 0 || probeInterval <= 0 {
+		return fmt.Errorf("pods, duration and probe interval must be more than zero")
+	}
+
+	run, err := startRun(testFlags, "resilience")
+	if err != nil {
+		return err
+	}
+	defer run.close()
+	rt, ctx := run.rt, run.ctx
+
+	logging.FromContext(ctx).WithField(logging.Phase, "create").Infof("Starting %d pods", totalPods)
+	created, failures := createPods(ctx, rt, testFlags.PodConfigFile, 0, totalPods, testFlags.Threads)
+	if len(created) == 0 {
+		tracker.Stop()
+		return fmt.Errorf("none of the %d pods started", failures)
+	}
+	before := runningPods()
+
+	result := &ResilienceResult{Pods: len(before), RestartCommand: restartCommand}
+	rctx := logging.WithField(ctx, logging.Phase, "resilience")
+	if restartCommand != "" {
+		go func() {
+			logging.FromContext(rctx).Infof("Restarting the runtime with %q", restartCommand)
+			out, err := exec.Command("sh", "-c", restartCommand).CombinedOutput()
+			if err != nil {
+				logging.FromContext(rctx).WithError(err).Errorf("restart command failed: %s", strings.TrimSpace(string(out)))
+			}
+		}()
+	} else {
+		logging.FromContext(rctx).Infof("Waiting up to %s for the runtime to be restarted", duration)
+	}
+	probeRuntime(rctx, rt, duration, probeInterval, result)
+
+	if result.Restarted {
+		logging.FromContext(rctx).Infof("Runtime was unavailable for %s", result.Downtime)
+		if err := podsSurvived(rctx, rt, before, result); err != nil {
+			logging.FromContext(rctx).WithError(err).Error("unable to list pods after the restart")
+		}
+	} else {
+		logging.FromContext(rctx).Warnf("Runtime was not restarted within %s", duration)
+	}
+	result.Outages = rt.Outages()
+
+	logging.FromContext(ctx).WithField(logging.Phase, "stop").Info("Stopping pods")
+	stopPods(ctx, rt, testFlags.Threads)
+	removeStoppedPods(ctx, rt)
+	tracker.Stop()
+
+	finishRun(run, &Results{Resilience: result},
+		resultSection{"Resilience", func() { ResilienceWriter(result) }})
+
+	if !result.Restarted {
+		return fmt.Errorf("the runtime was never unavailable within %s", duration)
+	}
+	if len(result.MissingPods) > 0 {
+		return fmt.Errorf("%d of %d pods were missing after the restart", len(result.MissingPods), result.Pods)
+	}
+	return nil
+}
+
+// probeRuntime probes the runtime every interval until it has been unavailable and come back, or duration passes
+func probeRuntime(ctx context.Context, rt *cri.Runtime, duration, interval time.Duration, result *ResilienceResult) {
+	var down time.Time
+	deadline := time.Now().Add(duration)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := time.Now(); now.Before(deadline); now = <-ticker.C {
+		err := rt.Probe(ctx, probeTimeout)
+		result.Probes++
+		switch {
+		case err != nil:
+			result.FailedProbes++
+			if down.IsZero() {
+				down = now
+				logging.FromContext(ctx).WithError(err).Warn("runtime unavailable")
+			}
+		case !down.IsZero():
+			result.Restarted = true
+			result.Downtime = time.Since(down)
+			return
+		}
+	}
+}
+
+// podsSurvived lists the pod sandboxes and checks every pod from before the restart is still there, and its containers running
+func podsSurvived(ctx context.Context, rt *cri.Runtime, before []testPod, result *ResilienceResult) error {
+	sandboxes, err := rt.ListPodSandboxes(ctx)
+	if err != nil {
+		return err
+	}
+	listed := map[string]*criapi.PodSandbox{}
+	for _, s := range sandboxes {
+		listed[s.GetId()] = s
+	}
+
+	for _, p := range before {
+		pod := *p.Pod
+		sandbox, ok := listed[pod.PodID()]
+		if !ok {
+			result.MissingPods = append(result.MissingPods, pod.PodID())
+			continue
+		}
+		result.Survived++
+		if sandbox.GetState() == criapi.PodSandboxState_SANDBOX_READY {
+			result.Ready++
+		}
+		for _, c := range pod.Containers() {
+			result.Containers++
+			status, err := rt.ContainerStatus(p.context(ctx), c)
+			if err != nil {
+				logging.FromContext(p.context(ctx)).WithError(err).Error("unable to get container status")
+				continue
+			}
+			if status.GetState() == criapi.ContainerState_CONTAINER_RUNNING {
+				result.Running++
+			}
+		}
+	}
+	sort.Strings(result.MissingPods)
+	return nil
+}
+
+// ResilienceWriter writes the resilience test results to the terminal
+func ResilienceWriter(result *ResilienceResult) {
+	downtime := "-"
+	if result.Restarted {
+		downtime = result.Downtime.Round(time.Millisecond).String()
+	}
+	outages := []string{}
+	for _, o := range result.Outages {
+		outages = append(outages, o.Duration().Round(time.Millisecond).String())
+	}
+
+	tableWriter := table.NewWriter()
+	tableWriter.SetOutputMirror(os.Stdout)
+	tableWriter.AppendHeader(table.Row{"Pods", "Downtime", "Failed Probes", "Connection Outages", "Survived", "Ready", "Missing", "Containers Running"})
+	tableWriter.AppendRow(table.Row{result.Pods, downtime, fmt.Sprintf("%d/%d", result.FailedProbes, result.Probes), strings.Join(outages, ", "),
+		result.Survived, result.Ready, len(result.MissingPods), fmt.Sprintf("%d/%d", result.Running, result.Containers)})
+	tableWriter.Render()
+	for _, id := range result.MissingPods {
+		fmt.Printf("missing pod %s\n", id)
+	}
+}
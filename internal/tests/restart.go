@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Klaven/cospeck/internal/logging"
+	"github.com/Klaven/cospeck/internal/runtime/cri"
+	"github.com/Klaven/cospeck/internal/stats"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/limiter"
+)
+
+// RestartResult is how many container restarts a restart test made and how many of them failed
+type RestartResult struct {
+	Restarts int `json:"restarts"`
+	Failures int `json:"failures"`
+}
+
+// RestartTest creates pods and then restarts every container in them restarts times, keeping the sandboxes alive.
+// Each restart stops and removes the container and creates and starts its next attempt, like the kubelet does
+// for a crashed container, and is timed separately from creating the pod. It returns an error if any restart failed
+func RestartTest(testFlags *TestFlags, totalPods, restarts int) error {
+	if totalPods < 1 || restarts < 1 {
+		return fmt.Errorf("pods and restarts must be more than zero")
+	}
+
+	run, err := startRun(testFlags, "restart")
+	if err != nil {
+		return err
+	}
+	defer run.close()
+	rt, ctx, sampler := run.rt, run.ctx, run.sampler
+
+	metricsRuntime := []stats.Metrics{}
+	sample := func(name string) {
+		total, err := sampler.Sample(name)
+		if err != nil {
+			logging.FromContext(ctx).WithError(err).Error("unable to sample the runtime cgroup")
+			return
+		}
+		metricsRuntime = append(metricsRuntime, *total)
+	}
+	sample("init")
+
+	logging.FromContext(ctx).WithField(logging.Phase, "create").Infof("Starting %d pods", totalPods)
+	created, _ := createPods(ctx, rt, testFlags.PodConfigFile, 0, totalPods, testFlags.Threads)
+	sample("pods-created")
+
+	var timings []cri.RestartTiming
+	failures := 0
+	for round := 1; round <= restarts; round++ {
+		rctx := logging.WithFields(ctx, log.Fields{logging.Phase: "restart", "round": round})
+		logging.FromContext(rctx).Infof("Restarting containers, round %d of %d", round, restarts)
+		t, f := restartContainers(rctx, rt, testFlags.Threads)
+		timings = append(timings, t...)
+		failures += f
+	}
+	sample("restarted")
+
+	logging.FromContext(ctx).WithField(logging.Phase, "stop").Info("Stopping pods")
+	stopPods(ctx, rt, testFlags.Threads)
+	removeStoppedPods(ctx, rt)
+	tracker.Stop()
+
+	var stop, remove, create, start, total []time.Duration
+	for _, t := range timings {
+		stop = append(stop, t.Stop)
+		remove = append(remove, t.Remove)
+		create = append(create, t.Create)
+		start = append(start, t.Start)
+		total = append(total, t.Total)
+	}
+	lifecycle := []LifecycleStat{
+		newLifecycleStat("pod create", created),
+		newLifecycleStat("restart", total),
+		newLifecycleStat("restart stop", stop),
+		newLifecycleStat("restart remove", remove),
+		newLifecycleStat("restart create", create),
+		newLifecycleStat("restart start", start),
+	}
+
+	result := &RestartResult{Restarts: len(timings) + failures, Failures: failures}
+	finishRun(run, &Results{Runtime: metricsRuntime, Lifecycle: lifecycle, Restart: result},
+		resultSection{"Restarts", func() {
+			LifecycleWriter(lifecycle)
+			fmt.Printf("%d restarts, %d failed\n", result.Restarts, result.Failures)
+		}},
+		resultSection{"Runtime Metrics", func() { MetricsWriter(&metricsRuntime) }})
+
+	if failures > 0 {
+		return fmt.Errorf("%d of %d restarts failed", failures, result.Restarts)
+	}
+	return nil
+}
+
+// restartContainers restarts every container of the running pods once, threads pods at a time
+func restartContainers(ctx context.Context, rt *cri.Runtime, threads int) ([]cri.RestartTiming, int) {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		timings  []cri.RestartTiming
+		failures int
+	)
+
+	l := limiter.New(threads)
+	for _, p := range runningPods() {
+		l.Begin()
+		wg.Add(1)
+		go func(p testPod) {
+			defer wg.Done()
+			defer l.End()
+			pctx := p.context(ctx)
+			for _, c := range (*p.Pod).Containers() {
+				_, timing, err := rt.RestartContainer(pctx, *p.Pod, c)
+				mu.Lock()
+				if err != nil {
+					failures++
+					logging.FromContext(pctx).WithError(err).WithField("container", c.Name()).Error("unable to restart container")
+				} else {
+					timings = append(timings, timing)
+				}
+				mu.Unlock()
+			}
+		}(p)
+	}
+	wg.Wait()
+	return timings, failures
+}
@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Klaven/cospeck/internal/logging"
+	"github.com/Klaven/cospeck/internal/progress"
+	"github.com/Klaven/cospeck/internal/runtime/cri"
+	"github.com/Klaven/cospeck/internal/stats"
+	"github.com/Klaven/cospeck/internal/trace"
+)
+
+// testRun is what every test sets up before starting pods: the runtime, a sampler of its cgroup,
+// the tracer and the runs metadata. ctx carries the tracer and the run id
+type testRun struct {
+	flags   *TestFlags
+	rt      *cri.Runtime
+	sampler *stats.CGroupsSampler
+	tracer  *trace.Tracer
+	meta    *RunMetadata
+	ctx     context.Context
+}
+
+// resultSection is a titled part of a tests terminal output
+type resultSection struct {
+	title string
+	write func()
+}
+
+// startRun connects to the runtime, removes any pods left on it and starts the progress tracker.
+// The run must be closed once the test is over, which cleans the runtime again
+func startRun(testFlags *TestFlags, test string) (*testRun, error) {
+	sampler, err := stats.NewCGroupsSampler(testFlags.CGroupPath)
+	if err != nil {
+		return nil, err
+	}
+
+	rt, err := newRuntime(testFlags)
+	if err != nil {
+		return nil, err
+	}
+
+	tracer := newTracer(testFlags)
+	ctx := trace.WithTracer(context.Background(), tracer)
+	meta := newRunMetadata(ctx, rt, testFlags, test)
+	ctx = logging.WithField(ctx, logging.RunID, meta.RunID)
+
+	rt.Clean(ctx)
+
+	tracker = progress.New(testFlags.Progress, sampler.Memory)
+	tracker.Start(progressInterval)
+
+	return &testRun{flags: testFlags, rt: rt, sampler: sampler, tracer: tracer, meta: meta, ctx: ctx}, nil
+}
+
+// close removes everything the run left on the runtime and disconnects from it
+func (run *testRun) close() {
+	run.rt.Clean(run.ctx)
+	run.rt.Close()
+}
+
+// finishRun prints the run metadata and the tests sections, writes the results file and exports the trace.
+// The results metadata is filled in from the run
+func finishRun(run *testRun, results *Results, sections ...resultSection) {
+	fmt.Println("--Run--")
+	MetadataWriter(run.meta)
+	for _, s := range sections {
+		fmt.Println("")
+		fmt.Println("--" + s.title + "--")
+		s.write()
+	}
+
+	results.Metadata = run.meta
+	if err := ResultsWriter(run.flags, results); err != nil {
+		logging.FromContext(run.ctx).WithError(err).Error("unable to write results")
+	}
+	exportTrace(run.ctx, run.flags, run.tracer)
+}
+
+// requireWorkload selects the built in workload a test runs, which can't be swapped for another one or a pod spec
+func requireWorkload(testFlags *TestFlags, test, workload string) error {
+	if testFlags.PodConfigFile != "" || (testFlags.Workload != "" && testFlags.Workload != workload) {
+		return fmt.Errorf("the %s test runs the %s workload, it can't be combined with --pod-configfile or --workload", test, workload)
+	}
+	testFlags.Workload = workload
+	return nil
+}
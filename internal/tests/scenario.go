@@ -0,0 +1,294 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Klaven/cospeck/internal/logging"
+	"github.com/Klaven/cospeck/internal/runtime/cri"
+	"github.com/Klaven/cospeck/internal/scenario"
+	"github.com/Klaven/cospeck/internal/stats"
+	"github.com/jedib0t/go-pretty/table"
+)
+
+// PhaseResult is what a single scenario phase measured
+type PhaseResult struct {
+	Name      string          `json:"name"`
+	Type      string          `json:"type"`
+	Duration  time.Duration   `json:"duration"`
+	Pods      int             `json:"pods"`
+	Failures  int             `json:"failures"`
+	Latencies []time.Duration `json:"latencies,omitempty"`
+}
+
+// AssertionResult is the outcome of a scenario assertion
+type AssertionResult struct {
+	Metric string  `json:"metric"`
+	Max    string  `json:"max"`
+	Actual float64 `json:"actual"`
+	Passed bool    `json:"passed"`
+}
+
+// scenarioRun holds the state of a running scenario
+type scenarioRun struct {
+	rt      *cri.Runtime
+	sampler *stats.CGroupsSampler
+	next    int
+
+	mu         sync.Mutex
+	runtime    []stats.Metrics
+	containers []stats.MetricsV2
+
+	create   []time.Duration
+	stop     []time.Duration
+	exec     []time.Duration
+	failures int
+}
+
+// ScenarioTest runs a declarative scenario file, it returns an error if the scenario could not run or an assertion failed
+func ScenarioTest(testFlags *TestFlags, file string) error {
+	s, err := scenario.Load(file)
+	if err != nil {
+		return err
+	}
+	if s.Runtime != "" {
+		testFlags.OCIRuntime = s.Runtime
+	}
+	if s.CGroupPath != "" {
+		testFlags.CGroupPath = s.CGroupPath
+	}
+	for i := range s.Phases {
+		if s.Phases[i].Pod == "" {
+			s.Phases[i].Pod = testFlags.PodConfigFile
+		}
+	}
+
+	run, err := startRun(testFlags, "scenario "+s.Name)
+	if err != nil {
+		return err
+	}
+	defer run.close()
+	rt, ctx := run.rt, run.ctx
+
+	state := &scenarioRun{rt: rt, sampler: run.sampler}
+	state.sample(ctx, "init")
+
+	var sampling sync.WaitGroup
+	stopSampling := make(chan struct{})
+	if s.SampleInterval.Duration > 0 {
+		sampling.Add(1)
+		go func() {
+			defer sampling.Done()
+			state.sampleEvery(ctx, s.SampleInterval.Duration, stopSampling)
+		}()
+	}
+
+	phases := []PhaseResult{}
+	for _, phase := range s.Phases {
+		pctx := logging.WithField(ctx, logging.Phase, phase.Name)
+		logging.FromContext(pctx).WithField("type", phase.Type).Info("Starting phase")
+		phases = append(phases, state.phase(pctx, phase))
+	}
+
+	// a sample in flight finishes before the samples are read
+	close(stopSampling)
+	sampling.Wait()
+	tracker.Stop()
+
+	// anything the scenario left behind
+	stopPods(ctx, rt, s.Concurrency)
+	removeStoppedPods(ctx, rt)
+
+	assertions := state.assert(s.Assertions)
+
+	sections := []resultSection{
+		{"Phases", func() { PhaseWriter(phases) }},
+		{"Container Metrics", func() { MetricsV2Writer(&state.containers) }},
+		{"Runtime Metrics", func() { MetricsWriter(&state.runtime) }},
+	}
+	if len(assertions) > 0 {
+		sections = append(sections, resultSection{"Assertions", func() { AssertionWriter(assertions) }})
+	}
+	finishRun(run, &Results{
+		Runtime:    state.runtime,
+		Containers: state.containers,
+		Phases:     phases,
+		Assertions: assertions,
+	}, sections...)
+
+	for _, a := range assertions {
+		if !a.Passed {
+			return fmt.Errorf("assertion failed: %s was %.3f, max %s", a.Metric, a.Actual, a.Max)
+		}
+	}
+	return nil
+}
+
+func (run *scenarioRun) phase(ctx context.Context, phase scenario.Phase) PhaseResult {
+	result := PhaseResult{Name: phase.Name, Type: phase.Type}
+	start := time.Now()
+
+	switch phase.Type {
+	case scenario.Create:
+		result.Latencies, result.Failures = createPods(ctx, run.rt, phase.Pod, run.next, phase.Count, phase.Concurrency)
+		run.next += phase.Count
+		result.Pods = len(result.Latencies)
+		run.record(&run.create, result.Latencies, result.Failures)
+	case scenario.Wait:
+		time.Sleep(phase.Duration.Duration)
+	case scenario.Churn:
+		var churned churnResult
+		churned, run.next = churn(ctx, run.rt, phase.Pod, run.next, phase.Rate, phase.Duration.Duration, phase.Concurrency)
+		result.Latencies, result.Failures, result.Pods = churned.Create, churned.Failures, churned.Replaced
+		run.record(&run.create, churned.Create, churned.Failures)
+		run.record(&run.stop, churned.Stop, 0)
+	case scenario.Exec:
+		result.Latencies, result.Failures = execLoad(ctx, run.rt, phase.Command, phase.Concurrency, phase.Duration.Duration)
+		result.Pods = len(runningPods())
+		run.record(&run.exec, result.Latencies, result.Failures)
+	case scenario.Stop:
+		result.Latencies = stopPods(ctx, run.rt, phase.Concurrency)
+		result.Pods = len(result.Latencies)
+		run.record(&run.stop, result.Latencies, 0)
+	case scenario.Remove:
+		result.Pods = removeStoppedPods(ctx, run.rt)
+	case scenario.Sample:
+		run.sample(ctx, phase.Name)
+	}
+
+	result.Duration = time.Since(start)
+	return result
+}
+
+func (run *scenarioRun) record(into *[]time.Duration, latencies []time.Duration, failures int) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	*into = append(*into, latencies...)
+	run.failures += failures
+}
+
+// sample takes a runtime and container sample named name
+func (run *scenarioRun) sample(ctx context.Context, name string) {
+	total, err := run.sampler.Sample(name)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).Error("unable to sample the runtime cgroup")
+	}
+	containers, err := stats.Stats(run.rt, name)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).Error("unable to get container stats")
+	}
+
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if total != nil {
+		run.runtime = append(run.runtime, *total)
+	}
+	if containers != nil {
+		run.containers = append(run.containers, *containers)
+	}
+}
+
+func (run *scenarioRun) sampleEvery(ctx context.Context, interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case t := <-ticker.C:
+			run.sample(ctx, t.Format("15:04:05"))
+		}
+	}
+}
+
+// value returns the current value of a scenario metric, latencies in seconds
+func (run *scenarioRun) value(metric string) float64 {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	switch metric {
+	case "create.p50":
+		return stats.Percentile(run.create, 50).Seconds()
+	case "create.p90":
+		return stats.Percentile(run.create, 90).Seconds()
+	case "create.p99":
+		return stats.Percentile(run.create, 99).Seconds()
+	case "create.max":
+		return stats.Percentile(run.create, 100).Seconds()
+	case "stop.p99":
+		return stats.Percentile(run.stop, 99).Seconds()
+	case "exec.p50":
+		return stats.Percentile(run.exec, 50).Seconds()
+	case "exec.p99":
+		return stats.Percentile(run.exec, 99).Seconds()
+	case "runtime.memory":
+		var max uint64
+		for _, m := range run.runtime {
+			if m.Mem > max {
+				max = m.Mem
+			}
+		}
+		return float64(max)
+	case "runtime.cpu":
+		var max float64
+		// the first sample has no previous sample to compare against
+		for i, m := range run.runtime {
+			if i > 0 && m.CPUPercent > max {
+				max = m.CPUPercent
+			}
+		}
+		return max * 100
+	case "failures":
+		return float64(run.failures)
+	}
+	return 0
+}
+
+func (run *scenarioRun) assert(assertions []scenario.Assertion) []AssertionResult {
+	results := []AssertionResult{}
+	for _, a := range assertions {
+		limit, _ := a.Limit()
+		actual := run.value(a.Metric)
+		results = append(results, AssertionResult{
+			Metric: a.Metric,
+			Max:    string(a.Max),
+			Actual: actual,
+			Passed: actual <= limit,
+		})
+	}
+	return results
+}
+
+// PhaseWriter writes scenario phases to the terminal
+func PhaseWriter(phases []PhaseResult) {
+	tableWriter := table.NewWriter()
+	tableWriter.SetOutputMirror(os.Stdout)
+	tableWriter.AppendHeader(table.Row{"Phase", "Type", "Duration", "Pods", "Failures", "p50", "p99"})
+	for _, p := range phases {
+		tableWriter.AppendRow(table.Row{p.Name, p.Type, p.Duration.Round(time.Millisecond), p.Pods, p.Failures,
+			stats.Percentile(p.Latencies, 50), stats.Percentile(p.Latencies, 99)})
+	}
+	tableWriter.Render()
+}
+
+// AssertionWriter writes scenario assertions to the terminal
+func AssertionWriter(assertions []AssertionResult) {
+	tableWriter := table.NewWriter()
+	tableWriter.SetOutputMirror(os.Stdout)
+	tableWriter.AppendHeader(table.Row{"Metric", "Max", "Actual", "Result"})
+	for _, a := range assertions {
+		result := "PASS"
+		if !a.Passed {
+			result = "FAIL"
+		}
+		actual := fmt.Sprintf("%.3f", a.Actual)
+		if scenario.IsLatency(a.Metric) {
+			actual = time.Duration(a.Actual * float64(time.Second)).Round(time.Millisecond).String()
+		}
+		tableWriter.AppendRow(table.Row{a.Metric, a.Max, actual, result})
+	}
+	tableWriter.Render()
+}
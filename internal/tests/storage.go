@@ -0,0 +1,176 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Klaven/cospeck/internal/logging"
+	"github.com/Klaven/cospeck/internal/runtime/cri"
+	"github.com/Klaven/cospeck/internal/stats"
+	"github.com/jedib0t/go-pretty/table"
+)
+
+// StorageSample is the writable layer and image filesystem usage at one point of a storage test
+type StorageSample struct {
+	Elapsed    time.Duration `json:"elapsed"`
+	Containers int           `json:"containers"`
+	// WritableBytes and WritableInodes are summed over the containers, MaxWritableBytes is the largest one
+	WritableBytes    uint64 `json:"writableBytes"`
+	WritableInodes   uint64 `json:"writableInodes"`
+	MaxWritableBytes uint64 `json:"maxWritableBytes"`
+	// ImageFsBytes and ImageFsInodes are summed over the image filesystems
+	ImageFsBytes  uint64 `json:"imageFsBytes"`
+	ImageFsInodes uint64 `json:"imageFsInodes"`
+	// PerContainer is each containers writable layer in bytes by container id
+	PerContainer map[string]uint64 `json:"perContainer"`
+}
+
+// StorageResult is the outcome of a storage test
+type StorageResult struct {
+	Pods      int             `json:"pods"`
+	WriteSize string          `json:"writeSize"`
+	Interval  time.Duration   `json:"interval"`
+	Baseline  StorageSample   `json:"baseline"`
+	Samples   []StorageSample `json:"samples"`
+	// the growth is the least squares slope against time, in bytes and inodes a minute
+	WritableGrowth float64 `json:"writableGrowth"`
+	ImageFsGrowth  float64 `json:"imageFsGrowth"`
+	InodeGrowth    float64 `json:"inodeGrowth"`
+}
+
+// StorageTest runs the disk workload and samples every containers writable layer from ContainerStats and the image filesystem
+// from ImageFsInfo every sampleInterval for duration, so snapshotters and storage drivers can be compared.
+// How much and how often the containers write are the disk workload parameters
+func StorageTest(testFlags *TestFlags, totalPods int, duration, sampleInterval time.Duration) error {
+	if totalPods < 1 || duration <= 0 || sampleInterval <= 0 {
+		return fmt.Errorf("pods, duration and sample interval must be more than zero")
+	}
+	if err := requireWorkload(testFlags, "storage", "disk"); err != nil {
+		return err
+	}
+
+	run, err := startRun(testFlags, "storage")
+	if err != nil {
+		return err
+	}
+	defer run.close()
+	rt, ctx := run.rt, run.ctx
+
+	result := &StorageResult{
+		Pods:      totalPods,
+		WriteSize: testFlags.WorkloadParams.WriteSize,
+		Interval:  testFlags.WorkloadParams.Interval,
+	}
+	start := time.Now()
+	if baseline, err := storageSample(ctx, rt, start); err != nil {
+		logging.FromContext(ctx).WithError(err).Error("unable to sample storage")
+	} else {
+		result.Baseline = *baseline
+	}
+
+	logging.FromContext(ctx).WithField(logging.Phase, "create").Infof("Starting %d pods", totalPods)
+	created, failures := createPods(ctx, rt, testFlags.PodConfigFile, 0, totalPods, testFlags.Threads)
+	if len(created) == 0 {
+		tracker.Stop()
+		return fmt.Errorf("none of the %d pods started", failures)
+	}
+	result.Pods = len(created)
+
+	sctx := logging.WithField(ctx, logging.Phase, "storage")
+	logging.FromContext(sctx).Infof("Sampling storage every %s for %s", sampleInterval, duration)
+	start = time.Now()
+	ticker := time.NewTicker(sampleInterval)
+	for now := start; now.Sub(start) <= duration; now = <-ticker.C {
+		s, err := storageSample(sctx, rt, start)
+		if err != nil {
+			logging.FromContext(sctx).WithError(err).Error("unable to sample storage")
+			continue
+		}
+		result.Samples = append(result.Samples, *s)
+	}
+	ticker.Stop()
+
+	logging.FromContext(ctx).WithField(logging.Phase, "stop").Info("Stopping pods")
+	stopPods(ctx, rt, testFlags.Threads)
+	removeStoppedPods(ctx, rt)
+	tracker.Stop()
+
+	storageGrowth(result)
+
+	finishRun(run, &Results{Storage: result},
+		resultSection{"Storage", func() { StorageWriter(result) }})
+	return nil
+}
+
+// storageSample reads the writable layer of every container and the image filesystems
+func storageSample(ctx context.Context, rt *cri.Runtime, start time.Time) (*StorageSample, error) {
+	containers, err := rt.ListContainerStats(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing container stats: %v", err)
+	}
+	filesystems, err := rt.ImageFsInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting image filesystem info: %v", err)
+	}
+
+	s := &StorageSample{Elapsed: time.Since(start), PerContainer: map[string]uint64{}}
+	for _, c := range containers {
+		layer := c.GetWritableLayer()
+		used := layer.GetUsedBytes().GetValue()
+		s.Containers++
+		s.WritableBytes += used
+		s.WritableInodes += layer.GetInodesUsed().GetValue()
+		if used > s.MaxWritableBytes {
+			s.MaxWritableBytes = used
+		}
+		s.PerContainer[c.GetAttributes().GetId()] = used
+	}
+	for _, fs := range filesystems {
+		s.ImageFsBytes += fs.GetUsedBytes().GetValue()
+		s.ImageFsInodes += fs.GetInodesUsed().GetValue()
+	}
+	return s, nil
+}
+
+// storageGrowth fits the writable layer, image filesystem and inode usage against time
+func storageGrowth(result *StorageResult) {
+	if len(result.Samples) < 2 {
+		return
+	}
+	var minutes, writable, imageFs, inodes []float64
+	for _, s := range result.Samples {
+		minutes = append(minutes, s.Elapsed.Minutes())
+		writable = append(writable, float64(s.WritableBytes))
+		imageFs = append(imageFs, float64(s.ImageFsBytes))
+		inodes = append(inodes, float64(s.ImageFsInodes))
+	}
+	result.WritableGrowth, _ = stats.Fit(minutes, writable)
+	result.ImageFsGrowth, _ = stats.Fit(minutes, imageFs)
+	result.InodeGrowth, _ = stats.Fit(minutes, inodes)
+}
+
+// StorageWriter writes the storage samples to the terminal
+func StorageWriter(result *StorageResult) {
+	mib := func(b float64) string { return fmt.Sprintf("%.1f", b/(1024*1024)) }
+
+	tableWriter := table.NewWriter()
+	tableWriter.SetOutputMirror(os.Stdout)
+	tableWriter.AppendHeader(table.Row{"Elapsed", "Containers", "Writable MiB", "Per Container MiB (max)", "Writable Inodes", "Image Fs MiB", "Image Fs Inodes"})
+	rows := append([]StorageSample{result.Baseline}, result.Samples...)
+	for i, s := range rows {
+		elapsed := s.Elapsed.Round(time.Second).String()
+		if i == 0 {
+			elapsed = "baseline"
+		}
+		perContainer := "-"
+		if s.Containers > 0 {
+			perContainer = fmt.Sprintf("%s (%s)", mib(float64(s.WritableBytes)/float64(s.Containers)), mib(float64(s.MaxWritableBytes)))
+		}
+		tableWriter.AppendRow(table.Row{elapsed, s.Containers, mib(float64(s.WritableBytes)), perContainer, s.WritableInodes, mib(float64(s.ImageFsBytes)), s.ImageFsInodes})
+	}
+	tableWriter.AppendFooter(table.Row{"growth/min", "", mib(result.WritableGrowth), "", "", mib(result.ImageFsGrowth), fmt.Sprintf("%.0f", result.InodeGrowth)})
+	tableWriter.Render()
+	fmt.Printf("%d pods writing %s every %s\n", result.Pods, result.WriteSize, result.Interval)
+}
@@ -0,0 +1,345 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Klaven/cospeck/internal/logging"
+	"github.com/Klaven/cospeck/internal/runtime/cri"
+	"github.com/Klaven/cospeck/internal/streaming"
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// streamTimeout is how long a single stream may take from dialing to the last byte
+	streamTimeout = time.Minute
+	// forwardChunk is how much is written to a port-forward at once
+	forwardChunk = 32 * 1024
+)
+
+// kinds of stream the streaming test opens
+const (
+	streamExec        = "exec"
+	streamPortForward = "port-forward"
+	streamAttach      = "attach"
+)
+
+// StreamStat is every stream of one kind opened at one concurrency. Setup is the Exec, Attach or PortForward call,
+// Connect the websocket dial and handshake and FirstByte from dialing to the first byte of data
+type StreamStat struct {
+	Kind        string        `json:"kind"`
+	Concurrency int           `json:"concurrency"`
+	Streams     int           `json:"streams"`
+	Failures    int           `json:"failures"`
+	Setup       LifecycleStat `json:"setup"`
+	Connect     LifecycleStat `json:"connect"`
+	FirstByte   LifecycleStat `json:"firstByte"`
+	Bytes       int64         `json:"bytes"`
+	// Throughput is the bytes a second all the streams moved together
+	Throughput float64 `json:"throughput"`
+}
+
+// StreamingResult is the outcome of a streaming test
+type StreamingResult struct {
+	Pods         int          `json:"pods"`
+	ExecBytes    int64        `json:"execBytes"`
+	ForwardBytes int64        `json:"forwardBytes"`
+	Stats        []StreamStat `json:"stats"`
+}
+
+// streamOutcome is what one stream measured
+type streamOutcome struct {
+	setup, connect, firstByte time.Duration
+	bytes                     int64
+	err                       error
+}
+
+// StreamingTest runs the echo workload and opens exec, port-forward and attach streams through the runtimes streaming server
+// at each concurrency. Exec streams read execBytes from stdout, port-forwards send forwardBytes to the echo server and read
+// them back, attaches are only set up and connected as the echo workload writes nothing to attach to
+func StreamingTest(testFlags *TestFlags, totalPods int, concurrency []int, execBytes, forwardBytes int64) error {
+	if totalPods < 1 || len(concurrency) == 0 || execBytes < 1 || forwardBytes < 1 {
+		return fmt.Errorf("pods, concurrency and the bytes to stream must be more than zero")
+	}
+	for _, c := range concurrency {
+		if c < 1 {
+			return fmt.Errorf("concurrency must be more than zero")
+		}
+	}
+	if err := requireWorkload(testFlags, "streaming", "echo"); err != nil {
+		return err
+	}
+
+	run, err := startRun(testFlags, "streaming")
+	if err != nil {
+		return err
+	}
+	defer run.close()
+	rt, ctx := run.rt, run.ctx
+
+	logging.FromContext(ctx).WithField(logging.Phase, "create").Infof("Starting %d pods", totalPods)
+	created, failures := createPods(ctx, rt, testFlags.PodConfigFile, 0, totalPods, testFlags.Threads)
+	if len(created) == 0 {
+		tracker.Stop()
+		return fmt.Errorf("none of the %d pods started", failures)
+	}
+
+	targets := runningPods()
+	port := int32(testFlags.WorkloadParams.Port)
+	result := &StreamingResult{Pods: len(created), ExecBytes: execBytes, ForwardBytes: forwardBytes}
+	kinds := []struct {
+		name string
+		open func(ctx context.Context, p testPod) streamOutcome
+	}{
+		{streamExec, func(ctx context.Context, p testPod) streamOutcome {
+			return execStream(ctx, rt, p, execBytes)
+		}},
+		{streamPortForward, func(ctx context.Context, p testPod) streamOutcome {
+			return forwardStream(ctx, rt, p, port, forwardBytes)
+		}},
+		{streamAttach, func(ctx context.Context, p testPod) streamOutcome {
+			return attachStream(ctx, rt, p)
+		}},
+	}
+	for _, level := range concurrency {
+		for _, kind := range kinds {
+			sctx := logging.WithFields(ctx, log.Fields{logging.Phase: "streaming", "stream": kind.name, "concurrency": level})
+			logging.FromContext(sctx).Infof("Opening %d %s streams at once", level, kind.name)
+			result.Stats = append(result.Stats, streamLevel(sctx, kind.name, level, targets, kind.open))
+		}
+	}
+
+	logging.FromContext(ctx).WithField(logging.Phase, "stop").Info("Stopping pods")
+	stopPods(ctx, rt, testFlags.Threads)
+	removeStoppedPods(ctx, rt)
+	tracker.Stop()
+
+	finishRun(run, &Results{Streaming: result},
+		resultSection{"Streaming", func() { StreamingWriter(result) }})
+	return nil
+}
+
+// streamLevel opens concurrency streams at once, spread over the pods, and waits for all of them to finish
+func streamLevel(ctx context.Context, kind string, concurrency int, targets []testPod, open func(context.Context, testPod) streamOutcome) StreamStat {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		outcomes []streamOutcome
+	)
+
+	start := time.Now()
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(p testPod) {
+			defer wg.Done()
+			o := open(p.context(ctx), p)
+			if o.err != nil {
+				logging.FromContext(p.context(ctx)).WithError(o.err).WithField("stream", kind).Error("stream failed")
+			}
+			mu.Lock()
+			outcomes = append(outcomes, o)
+			mu.Unlock()
+		}(targets[i%len(targets)])
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	stat := StreamStat{Kind: kind, Concurrency: concurrency, Streams: len(outcomes)}
+	var setup, connect, firstByte []time.Duration
+	for _, o := range outcomes {
+		if o.err != nil {
+			stat.Failures++
+			continue
+		}
+		setup = append(setup, o.setup)
+		connect = append(connect, o.connect)
+		firstByte = append(firstByte, o.firstByte)
+		stat.Bytes += o.bytes
+	}
+	stat.Setup = newLifecycleStat("setup", setup)
+	stat.Connect = newLifecycleStat("connect", connect)
+	stat.FirstByte = newLifecycleStat("first byte", firstByte)
+	stat.Throughput = float64(stat.Bytes) / elapsed.Seconds()
+	return stat
+}
+
+// execStream execs a command writing size bytes to stdout and reads all of it
+func execStream(ctx context.Context, rt *cri.Runtime, p testPod, size int64) streamOutcome {
+	var o streamOutcome
+	containers := (*p.Pod).Containers()
+	if len(containers) == 0 {
+		o.err = fmt.Errorf("pod has no containers")
+		return o
+	}
+
+	streamURL, setup, err := rt.Exec(ctx, containers[0], []string{"head", "-c", strconv.FormatInt(size, 10), "/dev/zero"})
+	o.setup = setup
+	if err != nil {
+		o.err = fmt.Errorf("exec: %v", err)
+		return o
+	}
+
+	dialed := time.Now()
+	conn, err := streaming.Dial(streamURL, nil, streamTimeout)
+	o.connect = time.Since(dialed)
+	if err != nil {
+		o.err = fmt.Errorf("connecting to the exec stream: %v", err)
+		return o
+	}
+	defer conn.Close()
+	conn.SetDeadline(dialed.Add(streamTimeout))
+
+	for {
+		channel, data, err := conn.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			o.err = fmt.Errorf("reading the exec stream: %v", err)
+			return o
+		}
+		switch channel {
+		case streaming.Stdout:
+			if o.bytes == 0 {
+				o.firstByte = time.Since(dialed)
+			}
+			o.bytes += int64(len(data))
+		case streaming.Error:
+			if err := streaming.StatusError(data); err != nil {
+				o.err = err
+				return o
+			}
+		}
+		if o.bytes >= size {
+			break
+		}
+	}
+	if o.bytes != size {
+		o.err = fmt.Errorf("exec stream ended after %d of %d bytes", o.bytes, size)
+	}
+	return o
+}
+
+// forwardStream forwards to the echo servers port in a pod, writes size bytes and reads them back.
+// The server starts each of the ports channels with the port number, which isn't data
+func forwardStream(ctx context.Context, rt *cri.Runtime, p testPod, port int32, size int64) streamOutcome {
+	var o streamOutcome
+	streamURL, setup, err := rt.PortForward(ctx, *p.Pod, []int32{port})
+	o.setup = setup
+	if err != nil {
+		o.err = fmt.Errorf("port-forward: %v", err)
+		return o
+	}
+
+	dialed := time.Now()
+	conn, err := streaming.Dial(streamURL, url.Values{"port": {strconv.Itoa(int(port))}}, streamTimeout)
+	o.connect = time.Since(dialed)
+	if err != nil {
+		o.err = fmt.Errorf("connecting to the port-forward stream: %v", err)
+		return o
+	}
+	defer conn.Close()
+	conn.SetDeadline(dialed.Add(streamTimeout))
+
+	const data, errors = 0, 1
+	written := make(chan error, 1)
+	go func() {
+		chunk := make([]byte, forwardChunk)
+		for sent := int64(0); sent < size; sent += int64(len(chunk)) {
+			if size-sent < int64(len(chunk)) {
+				chunk = chunk[:size-sent]
+			}
+			if err := conn.Write(data, chunk); err != nil {
+				written <- err
+				return
+			}
+		}
+		written <- nil
+	}()
+
+	prefix := map[byte]int{data: 2, errors: 2}
+	for o.bytes < size {
+		channel, payload, err := conn.Read()
+		if err != nil {
+			o.err = fmt.Errorf("reading the port-forward stream after %d of %d bytes: %v", o.bytes, size, err)
+			return o
+		}
+		if skip := prefix[channel]; skip > 0 {
+			if skip > len(payload) {
+				skip = len(payload)
+			}
+			prefix[channel] -= skip
+			payload = payload[skip:]
+		}
+		if len(payload) == 0 {
+			continue
+		}
+		switch channel {
+		case data:
+			if o.bytes == 0 {
+				o.firstByte = time.Since(dialed)
+			}
+			o.bytes += int64(len(payload))
+		case errors:
+			o.err = fmt.Errorf("port-forward: %s", payload)
+			return o
+		}
+	}
+	if err := <-written; err != nil {
+		o.err = fmt.Errorf("writing the port-forward stream: %v", err)
+	}
+	// both directions moved the data
+	o.bytes *= 2
+	return o
+}
+
+// attachStream attaches to a pods first container and connects, the stream is closed straight away
+func attachStream(ctx context.Context, rt *cri.Runtime, p testPod) streamOutcome {
+	var o streamOutcome
+	containers := (*p.Pod).Containers()
+	if len(containers) == 0 {
+		o.err = fmt.Errorf("pod has no containers")
+		return o
+	}
+
+	streamURL, setup, err := rt.Attach(ctx, containers[0])
+	o.setup = setup
+	if err != nil {
+		o.err = fmt.Errorf("attach: %v", err)
+		return o
+	}
+
+	dialed := time.Now()
+	conn, err := streaming.Dial(streamURL, nil, streamTimeout)
+	o.connect = time.Since(dialed)
+	if err != nil {
+		o.err = fmt.Errorf("connecting to the attach stream: %v", err)
+		return o
+	}
+	conn.Close()
+	return o
+}
+
+// StreamingWriter writes the streaming test results to the terminal
+func StreamingWriter(result *StreamingResult) {
+	tableWriter := table.NewWriter()
+	tableWriter.SetOutputMirror(os.Stdout)
+	tableWriter.AppendHeader(table.Row{"Stream", "Concurrency", "Failed", "Setup p50", "Setup p99", "Connect p50", "Connect p99", "First Byte p50", "First Byte p99", "MiB/s"})
+	for _, s := range result.Stats {
+		throughput := "-"
+		if s.Bytes > 0 {
+			throughput = fmt.Sprintf("%.1f", s.Throughput/(1024*1024))
+		}
+		tableWriter.AppendRow(table.Row{s.Kind, s.Concurrency, fmt.Sprintf("%d/%d", s.Failures, s.Streams),
+			s.Setup.P50, s.Setup.P99, s.Connect.P50, s.Connect.P99, s.FirstByte.P50, s.FirstByte.P99, throughput})
+	}
+	tableWriter.AppendFooter(table.Row{"pods", result.Pods, "exec bytes", result.ExecBytes, "forward bytes", result.ForwardBytes, "", "", "", ""})
+	tableWriter.Render()
+}
@@ -0,0 +1,244 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	criapi "github.com/Klaven/cospeck/cri"
+	"github.com/Klaven/cospeck/internal/logging"
+	"github.com/Klaven/cospeck/internal/runtime"
+	"github.com/Klaven/cospeck/internal/runtime/cri"
+	"github.com/Klaven/cospeck/internal/stats"
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/limiter"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// enforceTimeout is how long new limits may take to show up in the cgroup before they count as missing
+	enforceTimeout = 2 * time.Second
+	enforcePoll    = 10 * time.Millisecond
+)
+
+// pageSize is what the kernel rounds memory limits down to
+var pageSize = int64(os.Getpagesize())
+
+// UpdateResult is the outcome of an update test
+type UpdateResult struct {
+	Pods     int `json:"pods"`
+	Rounds   int `json:"rounds"`
+	Updates  int `json:"updates"`
+	Failures int `json:"failures"`
+	// Latency is the UpdateContainerResources round trip
+	Latency LifecycleStat `json:"latency"`
+	// Enforced updates were in the cgroup when the call returned, Late ones showed up within enforceTimeout
+	// and Missing ones never did. Unchecked containers had no cgroup to be found or already had the new limits
+	Enforced  int `json:"enforced"`
+	Late      int `json:"late"`
+	Missing   int `json:"missing"`
+	Unchecked int `json:"unchecked"`
+	// EnforceDelay is how long after the call returned the late updates showed up
+	EnforceDelay LifecycleStat `json:"enforceDelay"`
+}
+
+// updateOutcome is what one update did
+type updateOutcome struct {
+	latency, delay time.Duration
+	failed         bool
+	enforced       bool
+	unchecked      bool
+}
+
+// UpdateTest creates pods then resizes the cpu and memory limits of every container rounds times, going through cpus and memory
+// in turn each round. Every update is timed and the containers cgroup read back to check the new limits were applied.
+// It returns an error if any limits never showed up in the cgroup
+func UpdateTest(testFlags *TestFlags, totalPods, rounds int, cpus, memory []string) error {
+	if totalPods < 1 || rounds < 1 || len(cpus) == 0 || len(memory) == 0 {
+		return fmt.Errorf("pods and rounds must be more than zero and at least one cpu and memory size is needed")
+	}
+	cpuSizes, err := parseQuantities(cpus)
+	if err != nil {
+		return err
+	}
+	memorySizes, err := parseQuantities(memory)
+	if err != nil {
+		return err
+	}
+
+	run, err := startRun(testFlags, "update")
+	if err != nil {
+		return err
+	}
+	defer run.close()
+	rt, ctx := run.rt, run.ctx
+
+	logging.FromContext(ctx).WithField(logging.Phase, "create").Infof("Starting %d pods", totalPods)
+	created, failures := createPods(ctx, rt, testFlags.PodConfigFile, 0, totalPods, testFlags.Threads)
+	if len(created) == 0 {
+		tracker.Stop()
+		return fmt.Errorf("none of the %d pods started", failures)
+	}
+
+	if rounds > 1 && len(cpuSizes) == 1 && len(memorySizes) == 1 {
+		logging.FromContext(ctx).Warn("with a single cpu and memory size only the first round changes the limits, the others are not checked")
+	}
+
+	result := &UpdateResult{Pods: len(created), Rounds: rounds}
+	var latencies, delays []time.Duration
+	for round := 0; round < rounds; round++ {
+		cpu, mem := cpuSizes[round%len(cpuSizes)], memorySizes[round%len(memorySizes)]
+		rctx := logging.WithFields(ctx, log.Fields{logging.Phase: "update", "round": round + 1})
+		logging.FromContext(rctx).Infof("Setting limits to %s cpu and %s memory, round %d of %d", cpu.String(), mem.String(), round+1, rounds)
+
+		for _, o := range updateContainers(rctx, rt, cpu, mem, testFlags.Threads) {
+			result.Updates++
+			switch {
+			case o.failed:
+				result.Failures++
+				continue
+			case o.unchecked:
+				result.Unchecked++
+			case !o.enforced:
+				result.Missing++
+			case o.delay > 0:
+				result.Late++
+				delays = append(delays, o.delay)
+			default:
+				result.Enforced++
+			}
+			latencies = append(latencies, o.latency)
+		}
+	}
+	result.Latency = newLifecycleStat("update", latencies)
+	result.EnforceDelay = newLifecycleStat("enforce delay", delays)
+
+	logging.FromContext(ctx).WithField(logging.Phase, "stop").Info("Stopping pods")
+	stopPods(ctx, rt, testFlags.Threads)
+	removeStoppedPods(ctx, rt)
+	tracker.Stop()
+
+	finishRun(run, &Results{Update: result},
+		resultSection{"Updates", func() {
+			LifecycleWriter([]LifecycleStat{newLifecycleStat("pod create", created), result.Latency, result.EnforceDelay})
+			UpdateWriter(result)
+		}})
+
+	if result.Missing > 0 {
+		return fmt.Errorf("%d of %d updates never showed up in the containers cgroup", result.Missing, result.Updates)
+	}
+	return nil
+}
+
+// updateContainers sets the limits of every container of the running pods, threads pods at a time, and checks their cgroups
+func updateContainers(ctx context.Context, rt *cri.Runtime, cpu, memory resource.Quantity, threads int) []updateOutcome {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		outcomes []updateOutcome
+	)
+
+	l := limiter.New(threads)
+	for _, p := range runningPods() {
+		l.Begin()
+		wg.Add(1)
+		go func(p testPod) {
+			defer wg.Done()
+			defer l.End()
+			pctx := p.context(ctx)
+			for _, c := range (*p.Pod).Containers() {
+				o := updateContainer(pctx, rt, c, cpu, memory)
+				mu.Lock()
+				outcomes = append(outcomes, o)
+				mu.Unlock()
+			}
+		}(p)
+	}
+	wg.Wait()
+	return outcomes
+}
+
+// updateContainer updates one container and polls its cgroup until the limits show up or enforceTimeout passes
+func updateContainer(ctx context.Context, rt *cri.Runtime, c runtime.Container, cpu, memory resource.Quantity) updateOutcome {
+	ctx = logging.WithField(ctx, "container", c.Name())
+	// the cgroup is found first so the walk isn't counted as time for the limits to show up, and its limits are read
+	// so an update to the limits it already has isn't counted as enforced
+	cgroup, cgroupErr := stats.ContainerCGroup(c.ContainerID())
+	var before stats.CGroupLimits
+	var beforeErr error
+	if cgroupErr == nil {
+		before, beforeErr = stats.ReadCGroupLimits(cgroup)
+	}
+
+	sent, latency, err := rt.UpdateContainerResources(ctx, c, cpu, memory)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).Error("unable to update container resources")
+		return updateOutcome{failed: true}
+	}
+	returned := time.Now()
+
+	if cgroupErr != nil {
+		logging.FromContext(ctx).WithError(cgroupErr).Warn("unable to find the containers cgroup, its limits are not checked")
+		return updateOutcome{latency: latency, unchecked: true}
+	}
+	if beforeErr == nil && limitsApplied(before, sent) {
+		logging.FromContext(ctx).Debug("the container already had the new limits, they are not checked")
+		return updateOutcome{latency: latency, unchecked: true}
+	}
+
+	var limits stats.CGroupLimits
+	for first := true; ; first = false {
+		limits, err = stats.ReadCGroupLimits(cgroup)
+		if err == nil && limitsApplied(limits, sent) {
+			o := updateOutcome{latency: latency, enforced: true}
+			if !first {
+				o.delay = time.Since(returned)
+			}
+			return o
+		}
+		if time.Since(returned) > enforceTimeout {
+			break
+		}
+		time.Sleep(enforcePoll)
+	}
+	logging.FromContext(ctx).WithError(err).WithFields(log.Fields{"cgroup": cgroup, "found": fmt.Sprintf("%+v", limits)}).Error("new limits are not in the containers cgroup")
+	return updateOutcome{latency: latency}
+}
+
+// limitsApplied compares the limits in a cgroup with the ones sent, memory limits are rounded down to pages by the kernel
+func limitsApplied(limits stats.CGroupLimits, sent *criapi.LinuxContainerResources) bool {
+	if sent.MemoryLimitInBytes > 0 {
+		diff := sent.MemoryLimitInBytes - limits.Memory
+		if diff < 0 || diff >= pageSize {
+			return false
+		}
+	}
+	if sent.CpuQuota > 0 && (limits.CPUQuota != sent.CpuQuota || limits.CPUPeriod != sent.CpuPeriod) {
+		return false
+	}
+	return true
+}
+
+func parseQuantities(values []string) ([]resource.Quantity, error) {
+	quantities := make([]resource.Quantity, 0, len(values))
+	for _, v := range values {
+		q, err := resource.ParseQuantity(v)
+		if err != nil {
+			return nil, fmt.Errorf("size %q: %v", v, err)
+		}
+		quantities = append(quantities, q)
+	}
+	return quantities, nil
+}
+
+// UpdateWriter writes whether the updates reached the containers cgroups to the terminal
+func UpdateWriter(result *UpdateResult) {
+	tableWriter := table.NewWriter()
+	tableWriter.SetOutputMirror(os.Stdout)
+	tableWriter.AppendHeader(table.Row{"Updates", "Failed", "Enforced", "Late", "Missing", "Unchecked"})
+	tableWriter.AppendRow(table.Row{result.Updates, result.Failures, result.Enforced, result.Late, result.Missing, result.Unchecked})
+	tableWriter.Render()
+}
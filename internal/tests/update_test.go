@@ -0,0 +1,35 @@
+package tests
+
+import (
+	"testing"
+
+	criapi "github.com/Klaven/cospeck/cri"
+	"github.com/Klaven/cospeck/internal/stats"
+)
+
+func TestLimitsApplied(t *testing.T) {
+	const mib = 1024 * 1024
+	tests := []struct {
+		name   string
+		limits stats.CGroupLimits
+		sent   *criapi.LinuxContainerResources
+		want   bool
+	}{
+		{"exact", stats.CGroupLimits{Memory: 64 * mib, CPUQuota: 50000, CPUPeriod: 100000},
+			&criapi.LinuxContainerResources{MemoryLimitInBytes: 64 * mib, CpuQuota: 50000, CpuPeriod: 100000}, true},
+		{"memory rounded down to a page", stats.CGroupLimits{Memory: 64*mib - pageSize + 1, CPUQuota: -1},
+			&criapi.LinuxContainerResources{MemoryLimitInBytes: 64 * mib}, true},
+		{"memory not applied", stats.CGroupLimits{Memory: 128 * mib, CPUQuota: -1},
+			&criapi.LinuxContainerResources{MemoryLimitInBytes: 64 * mib}, false},
+		{"memory over a page off", stats.CGroupLimits{Memory: 64*mib - pageSize, CPUQuota: -1},
+			&criapi.LinuxContainerResources{MemoryLimitInBytes: 64 * mib}, false},
+		{"cpu not applied", stats.CGroupLimits{Memory: -1, CPUQuota: -1, CPUPeriod: 100000},
+			&criapi.LinuxContainerResources{CpuQuota: 50000, CpuPeriod: 100000}, false},
+		{"nothing sent", stats.CGroupLimits{Memory: -1, CPUQuota: -1}, &criapi.LinuxContainerResources{}, true},
+	}
+	for _, tt := range tests {
+		if got := limitsApplied(tt.limits, tt.sent); got != tt.want {
+			t.Errorf("%s: expected %v found %v", tt.name, tt.want, got)
+		}
+	}
+}
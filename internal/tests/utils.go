@@ -1,12 +1,23 @@
 package tests
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"os"
+	"time"
 
+	"github.com/Klaven/cospeck/internal/logging"
+	"github.com/Klaven/cospeck/internal/runtime/cri"
 	"github.com/Klaven/cospeck/internal/stats"
+	"github.com/Klaven/cospeck/internal/trace"
+	"github.com/Klaven/cospeck/internal/workloads"
 	"github.com/jedib0t/go-pretty/table"
 )
 
+// how often the progress line is redrawn
+const progressInterval = 500 * time.Millisecond
+
 // TestFlags is a struct that represents the flags that can be passed to flags
 type TestFlags struct {
 	Tests         string
@@ -14,7 +25,23 @@ type TestFlags struct {
 	CGroupPath    string
 	PodConfigFile string
 	Threads       int
-	cleanRuntime  bool
+	ResultsFile   string
+	TraceEndpoint string
+	TraceFile     string
+	Progress      bool
+	// SandboxConfigFile and ContainerConfigFile replace the compiled in base configs when set
+	SandboxConfigFile   string
+	ContainerConfigFile string
+	// Workload is a built in workload to run instead of the default pod, see the workloads package
+	Workload       string
+	WorkloadParams workloads.Params
+	// ArrivalRate switches pod creation to open loop, pods a second arriving by Arrival, MaxInFlight caps concurrent creates
+	ArrivalRate float64
+	Arrival     string
+	MaxInFlight int
+	// CommandLine holds every flag and its value for the run metadata
+	CommandLine  map[string]string
+	cleanRuntime bool
 }
 
 // MetricsWriter writes metrics to the terminal
@@ -38,3 +65,132 @@ func MetricsV2Writer(metrics *[]stats.MetricsV2) {
 	}
 	tableWriter.Render()
 }
+
+// runtimeTimeout is how long a single cri call may take
+const runtimeTimeout = 30 * time.Second
+
+// newRuntime connects to the runtime socket using the base configs from the flags
+func newRuntime(testFlags *TestFlags) (*cri.Runtime, error) {
+	var sandboxConfig, containerConfig *io.Reader
+	if testFlags.SandboxConfigFile != "" {
+		f, err := os.Open(testFlags.SandboxConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		var r io.Reader = f
+		sandboxConfig = &r
+	}
+	if testFlags.ContainerConfigFile != "" {
+		f, err := os.Open(testFlags.ContainerConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		var r io.Reader = f
+		containerConfig = &r
+	}
+	rt, err := cri.NewCRIRuntime(testFlags.OCIRuntime, runtimeTimeout, sandboxConfig, containerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if testFlags.Workload != "" {
+		spec, err := PodSpec(testFlags)
+		if err != nil {
+			return nil, err
+		}
+		rt.SetDefaultPodSpec(spec)
+	}
+	return rt, nil
+}
+
+// PodSpec returns the pod spec a run uses, the built in workload if one is set, otherwise the pod spec file
+func PodSpec(testFlags *TestFlags) ([]byte, error) {
+	if testFlags.Workload == "" {
+		return cri.ReadPodSpec(testFlags.PodConfigFile)
+	}
+	if testFlags.PodConfigFile != "" {
+		return nil, fmt.Errorf("--workload and --pod-configfile can't be used together")
+	}
+	return workloads.Spec(testFlags.Workload, testFlags.WorkloadParams)
+}
+
+// newTracer returns a tracer if the run was asked to export traces, nil otherwise
+func newTracer(testFlags *TestFlags) *trace.Tracer {
+	if testFlags.TraceEndpoint == "" && testFlags.TraceFile == "" {
+		return nil
+	}
+	return trace.New("cospeck")
+}
+
+// exportTrace sends the pod lifecycle traces wherever the flags asked for them
+func exportTrace(ctx context.Context, testFlags *TestFlags, tracer *trace.Tracer) {
+	if tracer == nil {
+		return
+	}
+	if testFlags.TraceFile != "" {
+		if err := tracer.WriteFile(testFlags.TraceFile); err != nil {
+			logging.FromContext(ctx).WithError(err).Error("unable to write trace file")
+		}
+	}
+	if testFlags.TraceEndpoint != "" {
+		if err := tracer.Export(testFlags.TraceEndpoint); err != nil {
+			logging.FromContext(ctx).WithError(err).Error("unable to export traces")
+		}
+	}
+}
+
+// LifecycleStat summarises how long one step of the pod lifecycle took across all pods
+type LifecycleStat struct {
+	Name  string        `json:"name"`
+	Count int           `json:"count"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P99   time.Duration `json:"p99"`
+	Max   time.Duration `json:"max"`
+}
+
+// newLifecycleStat summarises the durations, zero durations are steps the pod didn't have and are skipped
+func newLifecycleStat(name string, durations []time.Duration) LifecycleStat {
+	measured := []time.Duration{}
+	for _, d := range durations {
+		if d > 0 {
+			measured = append(measured, d)
+		}
+	}
+	return LifecycleStat{
+		Name:  name,
+		Count: len(measured),
+		P50:   stats.Percentile(measured, 50),
+		P90:   stats.Percentile(measured, 90),
+		P99:   stats.Percentile(measured, 99),
+		Max:   stats.Percentile(measured, 100),
+	}
+}
+
+// podLifecycle summarises the create, init and stop times of the pods
+func podLifecycle(testPods []testPod) []LifecycleStat {
+	var create, init, stop []time.Duration
+	for _, p := range testPods {
+		create = append(create, p.CreationTime)
+		init = append(init, p.InitTime)
+		stop = append(stop, p.DestructionTime)
+	}
+	return []LifecycleStat{
+		newLifecycleStat("create", create),
+		newLifecycleStat("init", init),
+		newLifecycleStat("stop", stop),
+	}
+}
+
+// LifecycleWriter writes pod lifecycle latencies to the terminal
+func LifecycleWriter(lifecycle []LifecycleStat) {
+	tableWriter := table.NewWriter()
+	tableWriter.SetOutputMirror(os.Stdout)
+	tableWriter.AppendHeader(table.Row{"Step", "Pods", "p50", "p90", "p99", "Max"})
+	for _, l := range lifecycle {
+		tableWriter.AppendRow(table.Row{l.Name, l.Count, l.P50, l.P90, l.P99, l.Max})
+	}
+	tableWriter.Render()
+}
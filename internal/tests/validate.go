@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	criapi "github.com/Klaven/cospeck/cri"
+	"github.com/Klaven/cospeck/internal/runtime/cri"
+	"github.com/Klaven/cospeck/internal/stats"
+	"github.com/jedib0t/go-pretty/table"
+)
+
+// results of a validation check
+const (
+	checkOK   = "ok"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+// Check is the result of one validation check
+type Check struct {
+	Name   string
+	Result string
+	Detail string
+}
+
+// Validate checks the pod spec, configs, runtime socket, images and cgroup path a test would use,
+// so a bad config is caught before a long run. It returns an error if any check failed
+func Validate(testFlags *TestFlags) error {
+	checks := validate(testFlags)
+	CheckWriter(checks)
+
+	failed := 0
+	for _, c := range checks {
+		if c.Result == checkFail {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+func validate(testFlags *TestFlags) []Check {
+	checks := []Check{}
+	add := func(name, result, detail string) {
+		checks = append(checks, Check{Name: name, Result: result, Detail: detail})
+	}
+
+	spec, err := PodSpec(testFlags)
+	if err != nil {
+		add("pod spec", checkFail, err.Error())
+		return checks
+	}
+	if cri.IsTemplate(spec) {
+		spec, err = cri.ExpandPodSpec(spec, cri.TemplateData{Index: 0, RunID: "validate"})
+		if err != nil {
+			add("pod spec", checkFail, err.Error())
+			return checks
+		}
+	}
+	issues, err := cri.ValidatePodSpec(spec)
+	if err != nil {
+		add("pod spec", checkFail, err.Error())
+		return checks
+	}
+	for _, i := range issues {
+		if i.Unknown {
+			add("pod spec", checkFail, i.String())
+		} else {
+			add("pod spec", checkWarn, i.String())
+		}
+	}
+
+	rt, err := newRuntime(testFlags)
+	if err != nil {
+		add("configs", checkFail, err.Error())
+		return checks
+	}
+	defer rt.Close()
+	add("configs", checkOK, "sandbox and container configs loaded")
+
+	set, err := rt.LoadWorkloads(context.Background(), testFlags.PodConfigFile)
+	if err != nil {
+		add("workloads", checkFail, err.Error())
+	} else {
+		add("workloads", checkOK, set.String())
+	}
+
+	if _, err := os.Stat(testFlags.OCIRuntime); err != nil {
+		add("runtime", checkFail, err.Error())
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), runtimeTimeout)
+		defer cancel()
+		var version *criapi.VersionResponse
+		err := rt.Probe(ctx, probeTimeout)
+		if err == nil {
+			version, err = rt.Version(ctx)
+		}
+		if err != nil {
+			add("runtime", checkFail, fmt.Sprintf("%s does not answer the CRI Version call: %v", testFlags.OCIRuntime, err))
+		} else {
+			add("runtime", checkOK, fmt.Sprintf("%s %s (CRI %s)", version.GetRuntimeName(), version.GetRuntimeVersion(), version.GetRuntimeApiVersion()))
+
+			if set != nil {
+				for _, image := range set.Images() {
+					status, err := rt.ImageStatus(ctx, image)
+					switch {
+					case err != nil:
+						add("image", checkFail, fmt.Sprintf("%s: %v", image, err))
+					case status == nil:
+						add("image", checkWarn, fmt.Sprintf("%s is not present, it will be pulled during the run", image))
+					default:
+						add("image", checkOK, image)
+					}
+				}
+			}
+		}
+	}
+
+	if _, err := stats.NewCGroupsSampler(testFlags.CGroupPath); err != nil {
+		add("cgroup", checkFail, err.Error())
+	} else {
+		add("cgroup", checkOK, testFlags.CGroupPath)
+	}
+
+	return checks
+}
+
+// CheckWriter writes the validation checks as a table
+func CheckWriter(checks []Check) {
+	tableWriter := table.NewWriter()
+	tableWriter.SetOutputMirror(os.Stdout)
+	tableWriter.AppendHeader(table.Row{"Check", "Result", "Detail"})
+	for _, c := range checks {
+		tableWriter.AppendRow(table.Row{c.Name, c.Result, c.Detail})
+	}
+	tableWriter.Render()
+}
@@ -0,0 +1,128 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+type contextKey int
+
+const (
+	tracerKey contextKey = iota
+	spanKey
+)
+
+// Tracer collects finished spans for a test run
+type Tracer struct {
+	service string
+	mu      sync.Mutex
+	spans   []*Span
+}
+
+// Span is a single timed operation, spans sharing a trace id make up a pods lifecycle
+type Span struct {
+	TraceID    string
+	SpanID     string
+	ParentID   string
+	Name       string
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	Error      string
+	tracer     *Tracer
+}
+
+// New creates a tracer for the given service name
+func New(service string) *Tracer {
+	return &Tracer{service: service}
+}
+
+// WithTracer returns a context that spans will be recorded to, a nil tracer disables tracing
+func WithTracer(ctx context.Context, t *Tracer) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, tracerKey, t)
+}
+
+// Enabled reports whether the context has a tracer, for work that is only done to be traced
+func Enabled(ctx context.Context) bool {
+	t, _ := ctx.Value(tracerKey).(*Tracer)
+	return t != nil
+}
+
+// Start starts a span as a child of the span in the context, or a new trace if there is none.
+// If the context has no tracer the returned span is nil, all span methods are safe to call on nil
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	t, _ := ctx.Value(tracerKey).(*Tracer)
+	if t == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		SpanID:     newID(8),
+		Name:       name,
+		Start:      time.Now(),
+		Attributes: map[string]string{},
+		tracer:     t,
+	}
+	if parent, ok := ctx.Value(spanKey).(*Span); ok {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+	} else {
+		span.TraceID = newID(16)
+	}
+
+	return context.WithValue(ctx, spanKey, span), span
+}
+
+// WithSpan returns a context where new spans will be children of span
+func WithSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(WithTracer(ctx, span.tracer), spanKey, span)
+}
+
+// SetAttribute adds a key value pair to the span
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.Attributes[key] = value
+}
+
+// SetError marks the span as failed
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.Error = err.Error()
+}
+
+// Finish ends the span and records it with its tracer
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.End = time.Now()
+	s.tracer.mu.Lock()
+	s.tracer.spans = append(s.tracer.spans, s)
+	s.tracer.mu.Unlock()
+}
+
+// Spans returns the finished spans
+func (t *Tracer) Spans() []*Span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*Span{}, t.spans...)
+}
+
+func newID(size int) string {
+	b := make([]byte, size)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,110 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestSpanNesting(t *testing.T) {
+	tracer := New("cospeck")
+	ctx := WithTracer(context.Background(), tracer)
+
+	pctx, pod := Start(ctx, "pod")
+	_, create := Start(pctx, "container.create")
+	create.Finish()
+	_, other := Start(ctx, "other")
+	other.Finish()
+	pod.Finish()
+
+	spans := tracer.Spans()
+	if len(spans) != 3 {
+		t.Fatalf("Expected three spans found %d", len(spans))
+	}
+	if create.TraceID != pod.TraceID || create.ParentID != pod.SpanID {
+		t.Errorf("Expected container.create to be a child of pod, found trace %s parent %s", create.TraceID, create.ParentID)
+	}
+	if pod.ParentID != "" {
+		t.Errorf("Expected pod to be a root span found parent %s", pod.ParentID)
+	}
+	if other.TraceID == pod.TraceID {
+		t.Errorf("Expected a span started without a parent to start a new trace")
+	}
+
+	wctx := WithSpan(context.Background(), pod)
+	_, later := Start(wctx, "sandbox.stop")
+	if later.ParentID != pod.SpanID {
+		t.Errorf("Expected a span started under WithSpan to be a child of pod, found parent %s", later.ParentID)
+	}
+}
+
+func TestNoTracer(t *testing.T) {
+	ctx, span := Start(context.Background(), "pod")
+	if span != nil {
+		t.Fatalf("Expected no span without a tracer")
+	}
+	span.SetAttribute("k", "v")
+	span.SetError(errors.New("failed"))
+	span.Finish()
+	if WithSpan(ctx, span) != ctx {
+		t.Errorf("Expected WithSpan of a nil span to return the context")
+	}
+	if Enabled(ctx) || Enabled(WithTracer(ctx, nil)) {
+		t.Errorf("Expected tracing to be off without a tracer")
+	}
+	if !Enabled(WithTracer(ctx, New("test"))) {
+		t.Errorf("Expected tracing to be on with a tracer")
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	tracer := New("cospeck")
+	ctx := WithTracer(context.Background(), tracer)
+	pctx, pod := Start(ctx, "pod")
+	_, create := Start(pctx, "container.create")
+	create.SetAttribute("container", "web")
+	create.SetError(errors.New("image not found"))
+	create.Finish()
+	pod.Finish()
+
+	b, err := tracer.Marshal()
+	if err != nil {
+		t.Fatalf("Error marshalling spans: %s", err)
+	}
+	var req otlpRequest
+	if err := json.Unmarshal(b, &req); err != nil {
+		t.Fatalf("Error unmarshalling %s: %s", b, err)
+	}
+
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("Expected one resource and scope found %s", b)
+	}
+	if attrs := req.ResourceSpans[0].Resource.Attributes; len(attrs) != 1 || attrs[0].Key != "service.name" || attrs[0].Value.StringValue != "cospeck" {
+		t.Errorf("Expected the service.name resource attribute found %+v", attrs)
+	}
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("Expected two spans found %d", len(spans))
+	}
+
+	got := spans[0]
+	if got.Name != "container.create" || got.TraceID != pod.TraceID || got.ParentSpanID != pod.SpanID {
+		t.Errorf("Unexpected span %+v", got)
+	}
+	if len(got.TraceID) != 32 || len(got.SpanID) != 16 {
+		t.Errorf("Expected 16 and 8 byte hex ids found %s and %s", got.TraceID, got.SpanID)
+	}
+	if got.StartTimeUnixNano != unixNano(create.Start) || got.EndTimeUnixNano != unixNano(create.End) {
+		t.Errorf("Expected the span times in nanoseconds as strings found %s and %s", got.StartTimeUnixNano, got.EndTimeUnixNano)
+	}
+	if got.Status.Code != statusCodeError || got.Status.Message != "image not found" {
+		t.Errorf("Expected an error status found %+v", got.Status)
+	}
+	if len(got.Attributes) != 1 || got.Attributes[0].Key != "container" || got.Attributes[0].Value.StringValue != "web" {
+		t.Errorf("Expected the container attribute found %+v", got.Attributes)
+	}
+	if spans[1].Status.Code != statusCodeOK || spans[1].ParentSpanID != "" {
+		t.Errorf("Expected an ok root span found %+v", spans[1])
+	}
+}
@@ -0,0 +1,200 @@
+// Package workloads generates the pod specs of the built in workloads, so common benchmarks don't need a hand written spec.
+// Every workload runs a busybox shell loop that exits on SIGTERM, the container is pid 1 so it would otherwise ignore it
+package workloads
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// DefaultImage is a small public image with everything the workloads need
+const DefaultImage = "docker.io/library/busybox:latest"
+
+// logTick is how often the logs workload catches up with its rate
+const logTick = 10 * time.Millisecond
+
+// Params are the knobs of the built in workloads, each workload uses the ones that apply to it
+type Params struct {
+	Image string
+	// CPUs is how many busy loops the cpu workload runs
+	CPUs int
+	// Memory is how much the memory workload allocates, a kubernetes quantity such as 64Mi
+	Memory string
+	// WriteSize is how much the disk workload writes every Interval, a kubernetes quantity
+	WriteSize string
+	Interval  time.Duration
+	// LogRate is how many lines a second the log workload writes, each LogLineSize bytes long
+	LogRate     int
+	LogLineSize int
+	// Port is where the echo workload listens
+	Port int
+	// MemoryLimit is the containers memory limit, a kubernetes quantity, empty is no limit
+	MemoryLimit string
+}
+
+// DefaultParams returns the parameters used when no flags are given
+func DefaultParams() Params {
+	return Params{
+		Image:       DefaultImage,
+		CPUs:        1,
+		Memory:      "64Mi",
+		WriteSize:   "16Mi",
+		Interval:    time.Second,
+		LogRate:     100,
+		LogLineSize: 128,
+		Port:        7007,
+	}
+}
+
+type workload struct {
+	description string
+	script      func(p Params) (string, error)
+	ports       func(p Params) []int
+}
+
+var workloads = map[string]workload{
+	"sleeper": {
+		description: "idle container that sleeps until it is stopped",
+		script: func(p Params) (string, error) {
+			return "while true; do sleep 1; done", nil
+		},
+	},
+	"cpu": {
+		description: "burns --workload-cpus cpus in busy loops",
+		script: func(p Params) (string, error) {
+			if p.CPUs < 1 {
+				return "", fmt.Errorf("cpus must be at least 1")
+			}
+			return fmt.Sprintf("for i in $(seq %d); do while true; do :; done & done; wait", p.CPUs), nil
+		},
+	},
+	"memory": {
+		description: "allocates --workload-memory and holds it",
+		script: func(p Params) (string, error) {
+			size, err := quantityBytes(p.Memory)
+			if err != nil {
+				return "", err
+			}
+			// the shell keeps the whole string in memory for as long as it runs
+			return fmt.Sprintf("fill=$(head -c %d /dev/zero | tr '\\0' x); while true; do sleep 1; done", size), nil
+		},
+	},
+	"disk": {
+		description: "writes and fsyncs --workload-write-size to its writable layer every --workload-interval",
+		script: func(p Params) (string, error) {
+			size, err := quantityBytes(p.WriteSize)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("mkdir -p /var/tmp/cospeck; while true; do dd if=/dev/zero of=/var/tmp/cospeck/data bs=%d count=1 conv=fsync 2>/dev/null; sleep %s; done",
+				size, seconds(p.Interval)), nil
+		},
+	},
+	"logs": {
+		description: "writes --workload-log-rate numbered lines a second of --workload-log-line-size bytes to stdout",
+		script: func(p Params) (string, error) {
+			if p.LogRate < 1 || p.LogLineSize < 1 {
+				return "", fmt.Errorf("log rate and line size must be at least 1")
+			}
+			// lines are numbered so a reader can tell if any were dropped. Every logTick the lines due by the time elapsed
+			// since the start, read in centiseconds from /proc/uptime, are written, so time spent writing doesn't slow the rate
+			return fmt.Sprintf("line=$(head -c %d /dev/zero | tr '\\0' x); read up rest < /proc/uptime; start=$((${up%%.*}*100 + 1${up#*.} - 100)); i=0; "+
+				"while true; do read up rest < /proc/uptime; due=$(((${up%%.*}*100 + 1${up#*.} - 100 - start) * %d / 100 + 1)); "+
+				"while [ $i -lt $due ]; do echo \"$i $line\"; i=$((i+1)); done; usleep %d; done",
+				p.LogLineSize, p.LogRate, int(logTick/time.Microsecond)), nil
+		},
+	},
+	"echo": {
+		description: "tcp echo server on --workload-port",
+		script: func(p Params) (string, error) {
+			if p.Port < 1 || p.Port > 65535 {
+				return "", fmt.Errorf("port %d is out of range", p.Port)
+			}
+			return fmt.Sprintf("nc -lk -p %d -e cat & wait", p.Port), nil
+		},
+		ports: func(p Params) []int { return []int{p.Port} },
+	},
+}
+
+// Names returns the names of the built in workloads
+func Names() []string {
+	names := make([]string, 0, len(workloads))
+	for name := range workloads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Usage describes every workload, one per line
+func Usage() string {
+	lines := []string{}
+	for _, name := range Names() {
+		lines = append(lines, fmt.Sprintf("%s: %s", name, workloads[name].description))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Spec returns the pod spec of a built in workload
+func Spec(name string, p Params) ([]byte, error) {
+	w, ok := workloads[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown workload %q, expected one of %s", name, strings.Join(Names(), ", "))
+	}
+	if p.Image == "" {
+		p.Image = DefaultImage
+	}
+
+	script, err := w.script(p)
+	if err != nil {
+		return nil, fmt.Errorf("workload %s: %v", name, err)
+	}
+
+	spec := fmt.Sprintf(`apiVersion: v1
+kind: Pod
+metadata:
+  name: %s
+  labels:
+    cospeck.workload: %s
+spec:
+  containers:
+    - name: %s
+      image: %s
+      command: ["sh", "-c", %q]
+`, name, name, name, p.Image, "trap 'exit 0' TERM; "+script)
+
+	if p.MemoryLimit != "" {
+		if _, err := quantityBytes(p.MemoryLimit); err != nil {
+			return nil, fmt.Errorf("workload %s: memory limit: %v", name, err)
+		}
+		spec += fmt.Sprintf("      resources:\n        limits:\n          memory: %s\n", p.MemoryLimit)
+	}
+	if w.ports != nil {
+		spec += "      ports:\n"
+		for _, port := range w.ports(p) {
+			spec += fmt.Sprintf("        - containerPort: %d\n          protocol: TCP\n", port)
+		}
+	}
+	return []byte(spec), nil
+}
+
+// quantityBytes converts a kubernetes quantity to bytes
+func quantityBytes(quantity string) (int64, error) {
+	q, err := resource.ParseQuantity(quantity)
+	if err != nil {
+		return 0, fmt.Errorf("size %q: %v", quantity, err)
+	}
+	if q.Value() < 1 {
+		return 0, fmt.Errorf("size %q must be at least one byte", quantity)
+	}
+	return q.Value(), nil
+}
+
+// seconds formats a duration for busybox sleep, which takes fractions of a second
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%g", d.Seconds())
+}
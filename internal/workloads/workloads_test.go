@@ -0,0 +1,95 @@
+package workloads
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Klaven/cospeck/internal/runtime/cri"
+)
+
+func TestSpecs(t *testing.T) {
+	for _, name := range Names() {
+		spec, err := Spec(name, DefaultParams())
+		if err != nil {
+			t.Fatalf("Error generating workload %s: %s", name, err)
+		}
+
+		issues, err := cri.ValidatePodSpec(spec)
+		if err != nil || len(issues) != 0 {
+			t.Errorf("Expected workload %s to validate cleanly found %v %v", name, issues, err)
+		}
+
+		_, containers, err := cri.ParseYamlFile(spec)
+		if err != nil {
+			t.Fatalf("Error parsing workload %s: %s", name, err)
+		}
+		c := containers[0]
+		if c.Image.Image != DefaultImage || len(c.Command) != 3 || !strings.HasPrefix(c.Command[2], "trap 'exit 0' TERM; ") {
+			t.Errorf("Expected workload %s to run a shell that exits on SIGTERM found %v", name, c.Command)
+		}
+	}
+
+	memory, _ := Spec("memory", Params{Memory: "1Ki"})
+	_, containers, _ := cri.ParseYamlFile(memory)
+	if !strings.Contains(containers[0].Command[2], "head -c 1024 /dev/zero | tr '\\0' x") {
+		t.Errorf("Expected the memory workload to allocate 1024 bytes found %s", containers[0].Command[2])
+	}
+
+	limited, _ := Spec("memory", Params{Memory: "1Mi", MemoryLimit: "512Ki"})
+	_, containers, _ = cri.ParseYamlFile(limited)
+	if containers[0].Linux.Resources.MemoryLimitInBytes != 512*1024 {
+		t.Errorf("Expected a 512Ki memory limit found %d", containers[0].Linux.Resources.MemoryLimitInBytes)
+	}
+
+	if _, err := Spec("missing", DefaultParams()); err == nil {
+		t.Errorf("Expected an error for an unknown workload")
+	}
+}
+
+// TestLogsRate runs the logs workloads loop in the local shell against a fake clock: /proc/uptime is swapped for a file
+// that the usleep shim (usleep is a busybox applet) moves on by a tick each call, so the pacing is checked without waiting
+func TestLogsRate(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no shell to run the workload in")
+	}
+	dir, err := ioutil.TempDir("", "cospeck-logs")
+	if err != nil {
+		t.Fatalf("Error creating a temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	uptime := filepath.Join(dir, "uptime")
+	if err := ioutil.WriteFile(uptime, []byte("12345.67 1.00\n"), 0644); err != nil {
+		t.Fatalf("Error writing the fake uptime: %s", err)
+	}
+
+	const rate, ticks = 400, 200
+	script, err := workloads["logs"].script(Params{LogRate: rate, LogLineSize: 10})
+	if err != nil {
+		t.Fatalf("Error generating the logs workload: %s", err)
+	}
+	if !strings.Contains(script, "usleep 10000") {
+		t.Fatalf("Expected the logs workload to tick every 10ms found %s", script)
+	}
+	shim := fmt.Sprintf(`tick=0; usleep() { tick=$((tick+1)); [ $tick -ge %d ] && exit 0; cs=$((1234567+tick)); printf '%%d.%%02d 1.00\n' $((cs/100)) $((cs%%100)) > %s; }; `, ticks, uptime)
+	script = strings.Replace(script, "/proc/uptime", uptime, -1)
+
+	var out bytes.Buffer
+	cmd := exec.Command(sh, "-c", shim+script)
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Error running the logs workload: %s", err)
+	}
+
+	// the last lines were written on the tick before the shim exited, one line is written straight away
+	lines := bytes.Count(out.Bytes(), []byte("\n"))
+	if want := (ticks-1)*rate/100 + 1; lines != want {
+		t.Errorf("Expected %d lines after %d ticks of 10ms found %d", want, ticks, lines)
+	}
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Klaven/cospeck/internal/runtime/cri"
+	"github.com/Klaven/cospeck/internal/tests"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// configMarshal writes configs the way they are read back, by proto field name with enums by name and every field present
+var configMarshal = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}
+
+// marshalConfig marshals a config and indents it, protojson's own indenting varies its whitespace between builds
+func marshalConfig(m proto.Message) ([]byte, error) {
+	b, err := configMarshal.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, b, "", "\t"); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func configCmd(flags *Flags, testFlags *tests.TestFlags) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the configs cospeck runs with",
+	}
+
+	cmd.AddCommand(configDumpCmd(testFlags))
+
+	return cmd
+}
+
+func configDumpCmd(testFlags *tests.TestFlags) *cobra.Command {
+
+	var only string
+	cmd := &cobra.Command{
+		Use:   "dump",
+		Short: "Print the effective sandbox config, container config and pod spec",
+		Long:  "Print the effective sandbox config, container config and pod spec. Without flags these are the built in defaults, pick one with --only and redirect it to a file to use as a starting point for your own. The name of each one is written to stderr.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return configDump(testFlags, only)
+		},
+	}
+
+	cmd.Flags().StringVarP(&only, "only", "", "", "Print only one of sandbox, container or pod")
+	addConfigFlags(cmd, testFlags)
+
+	return cmd
+}
+
+// configDump prints the configs that a test run with the same flags would use, or only the one named
+func configDump(testFlags *tests.TestFlags, only string) error {
+	switch only {
+	case "", "sandbox", "container", "pod":
+	default:
+		return fmt.Errorf("unknown config %q, expected sandbox, container or pod", only)
+	}
+
+	var sandboxConfig, containerConfig io.Reader
+	if testFlags.SandboxConfigFile != "" {
+		f, err := os.Open(testFlags.SandboxConfigFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		sandboxConfig = f
+	}
+	if testFlags.ContainerConfigFile != "" {
+		f, err := os.Open(testFlags.ContainerConfigFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		containerConfig = f
+	}
+
+	sandbox, container, err := cri.LoadConfigs(sandboxConfig, containerConfig)
+	if err != nil {
+		return err
+	}
+	pod, err := tests.PodSpec(testFlags)
+	if err != nil {
+		return err
+	}
+
+	sandboxJSON, err := marshalConfig(sandbox)
+	if err != nil {
+		return err
+	}
+	containerJSON, err := marshalConfig(container)
+	if err != nil {
+		return err
+	}
+
+	// the names go to stderr so stdout is only the configs
+	for _, c := range []struct {
+		name, from string
+		config     []byte
+	}{
+		{"sandbox", "--sandbox-config", sandboxJSON},
+		{"container", "--container-config", containerJSON},
+		{"pod", "--pod-configfile or --workload", pod},
+	} {
+		if only != "" && only != c.name {
+			continue
+		}
+		if only == "" {
+			fmt.Fprintf(os.Stderr, "# %s (%s)\n", c.name, c.from)
+		}
+		fmt.Println(string(c.config))
+	}
+	return nil
+}
@@ -1,24 +1,47 @@
 package cmd
 
 import (
+	"fmt"
+
 	"github.com/Klaven/cospeck/internal/tests"
 	"github.com/spf13/cobra"
 )
 
 func nodeBusterCmd(flags *Flags, testFlags *tests.TestFlags) *cobra.Command {
+	var (
+		limits   tests.NodeBusterLimits
+		stopWhen string
+	)
 	cmd := &cobra.Command{
 		Use:   "nodebuster",
 		Short: "Test your nodes container runtime.... to it's limits",
-		Long:  "Test your container runtime, to it's limits! \n WARNING, do not run this on a node that is running production anything!!!!",
-		Run: func(cmd *cobra.Command, args []string) {
-			nodeBusterRunner(flags, testFlags)
+		Long:  "Test your container runtime, to it's limits! \n WARNING, do not run this on a node that is running production anything!!!!\n\nPods are created until a stop condition trips, the pods still being created are then waited for and everything is cleaned up. The pod count each condition tripped at is reported.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			switch stopWhen {
+			case "any":
+			case "all":
+				limits.StopOnAll = true
+			default:
+				return fmt.Errorf("unknown --stop-when %q, expected any or all", stopWhen)
+			}
+			return nodeBusterRunner(flags, testFlags, limits)
 		},
 	}
 
+	cmd.Flags().DurationVarP(&limits.MaxCreateLatency, "max-create-latency", "", 0, "Stop when the p90 create latency of the recent pods is above this, 0 is off")
+	cmd.Flags().Uint64VarP(&limits.MaxRuntimeMemory, "max-runtime-memory", "", 0, "Stop when the runtime cgroup uses more than this many MiB, 0 is off")
+	cmd.Flags().Uint64VarP(&limits.MinNodeAvailable, "min-node-available", "", 0, "Stop when the node has less than this many MiB available, 0 is off")
+	cmd.Flags().Float64VarP(&limits.MaxMemoryPressure, "max-memory-pressure", "", 0, "Stop when the memory pressure (PSI some avg10, percent) is above this, 0 is off")
+	cmd.Flags().Float64VarP(&limits.MaxErrorRate, "max-error-rate", "", 0, "Stop when more than this fraction of the recent creates failed, below 1. 0 stops at the first failure")
+	cmd.Flags().IntVarP(&limits.MaxPods, "max-pods", "", 0, "Stop at this many running pods, 0 is off")
+	cmd.Flags().StringVarP(&stopWhen, "stop-when", "", "any", "Stop when any condition trips, or keep going until all of them have")
+	addTestFlags(cmd, testFlags)
+
 	return cmd
 }
 
 // nodeBusterRunner will try and break your node
-func nodeBusterRunner(flags *Flags, testFlags *tests.TestFlags) {
-	tests.NodeBusterTest(testFlags)
+func nodeBusterRunner(flags *Flags, testFlags *tests.TestFlags, limits tests.NodeBusterLimits) error {
+	return tests.NodeBusterTest(testFlags, limits)
 }
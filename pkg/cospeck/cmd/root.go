@@ -1,14 +1,18 @@
 package cmd
 
 import (
+	"github.com/Klaven/cospeck/internal/logging"
 	"github.com/Klaven/cospeck/internal/tests"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 // Flags represent cmd line flags
 type Flags struct {
 	Runtime       string
 	CreateCluster bool
+	LogLevel      string
+	LogFormat     string
 }
 
 // RootCmd is the root command builder thing
@@ -19,10 +23,22 @@ func RootCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "cospeck",
 		Short: "A container runtime speed test",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := logging.Setup(globalFlags.LogLevel, globalFlags.LogFormat); err != nil {
+				return err
+			}
+
+			// recorded in the results so a run can be reproduced later
+			testFlags.CommandLine = map[string]string{}
+			cmd.Flags().VisitAll(func(f *pflag.Flag) {
+				testFlags.CommandLine[f.Name] = f.Value.String()
+			})
+			return nil
+		},
 	}
 
 	// subcommands
-	cmd.AddCommand(testCmd(globalFlags, testFlags), nodeBusterCmd(globalFlags, testFlags))
+	cmd.AddCommand(testCmd(globalFlags, testFlags), nodeBusterCmd(globalFlags, testFlags), runCmd(globalFlags, testFlags), configCmd(globalFlags, testFlags), validateCmd(globalFlags, testFlags))
 
 	// Flags
 	cmd.PersistentFlags().StringVarP(&globalFlags.Runtime, "runtime", "r", "/var/run/crio/crio.sock", "Runtime to use default: /var/run/crio/crio.sock")
@@ -30,5 +46,12 @@ func RootCmd() *cobra.Command {
 	// really I would like to take the kubernetes cluster out of it eventually. but right now it makes some things easy
 	cmd.PersistentFlags().BoolP("create-runtime", "c", true, "Create a cluster")
 
+	cmd.PersistentFlags().StringVarP(&globalFlags.LogLevel, "log-level", "", "info", "Log level: trace, debug, info, warn or error. Logs go to stderr")
+	cmd.PersistentFlags().StringVarP(&globalFlags.LogFormat, "log-format", "", "text", "Log format: text or json")
+	cmd.PersistentFlags().StringVarP(&testFlags.TraceEndpoint, "trace-endpoint", "", "", "Export pod lifecycle traces to this OTLP/HTTP collector, e.g. http://localhost:4318")
+	cmd.PersistentFlags().StringVarP(&testFlags.TraceFile, "trace-file", "", "", "Write pod lifecycle traces as OTLP json to this file")
+	cmd.PersistentFlags().BoolVarP(&testFlags.Progress, "progress", "", true, "Show a live progress line while tests run, off when stdout or stderr is not a terminal")
+	cmd.PersistentFlags().StringVarP(&testFlags.ResultsFile, "results-file", "o", "", "Write the results and run metadata as json to this file")
+
 	return cmd
 }
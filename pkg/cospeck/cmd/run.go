@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"github.com/Klaven/cospeck/internal/tests"
+	"github.com/spf13/cobra"
+)
+
+func runCmd(flags *Flags, testFlags *tests.TestFlags) *cobra.Command {
+	var file string
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "Run a benchmark scenario file",
+		Long:  "Run a declarative, multi-phase benchmark scenario. Phases can create, wait, churn, exec, stop, remove and sample, and assertions fail the run if a metric is over its limit.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return tests.ScenarioTest(testFlags, file)
+		},
+	}
+
+	cmd.Flags().StringVarP(&file, "file", "f", "", "The scenario file to run")
+	cmd.MarkFlagRequired("file")
+	addTestFlags(cmd, testFlags)
+
+	return cmd
+}
@@ -1,7 +1,10 @@
 package cmd
 
 import (
+	"time"
+
 	"github.com/Klaven/cospeck/internal/tests"
+	"github.com/Klaven/cospeck/internal/workloads"
 	"github.com/spf13/cobra"
 )
 
@@ -12,7 +15,7 @@ func testCmd(flags *Flags, testFlags *tests.TestFlags) *cobra.Command {
 		Short: "Test your container runtime",
 	}
 
-	cmd.AddCommand(GeneralTest(testFlags))
+	cmd.AddCommand(GeneralTest(testFlags), ChurnTest(testFlags), LeakTest(testFlags), ExecTest(testFlags), RestartTest(testFlags), DensityTest(testFlags), LoggingTest(testFlags), UpdateTest(testFlags), OOMTest(testFlags), StorageTest(testFlags), StreamingTest(testFlags), ResilienceTest(testFlags))
 
 	return cmd
 
@@ -33,11 +36,333 @@ func GeneralTest(testFlags *tests.TestFlags) *cobra.Command {
 
 	// Flags - maybe we should just use a config file for half of these.
 	cmd.Flags().IntVarP(&pods, "pods", "p", 100, "Number of pods to use when testing memory")
-	cmd.Flags().StringVarP(&testFlags.OCIRuntime, "runtime", "", "/var/run/crio/crio.sock", "The location of the runtime socket to use")
 	cmd.Flags().StringVarP(&testFlags.Tests, "tests", "t", "", "run only one test")
+	cmd.Flags().Float64VarP(&testFlags.ArrivalRate, "arrival-rate", "", 0, "Create pods open loop at this many a second, whether or not earlier pods are done. 0 keeps --threads pods in flight instead")
+	cmd.Flags().StringVarP(&testFlags.Arrival, "arrival", "", tests.ArrivalConstant, "Inter-arrival times of the open loop, constant or poisson")
+	cmd.Flags().IntVarP(&testFlags.MaxInFlight, "max-in-flight", "", 0, "Cap on concurrent creates in open loop mode, 0 is no cap. Time waiting for a slot counts as latency")
+	addTestFlags(cmd, testFlags)
+
+	return cmd
+}
+
+// ChurnTest replaces pods at a fixed rate for a while
+func ChurnTest(testFlags *tests.TestFlags) *cobra.Command {
+
+	var (
+		population       int
+		rate             float64
+		duration, window time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "churn",
+		Short: "sustained pod churn at a fixed create/delete rate",
+		Long:  "Fill the node to a population of pods, then replace the oldest pod at a fixed rate for a duration. Lifecycle latency and runtime memory/cpu are reported per window, with their drift per minute.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return tests.ChurnTest(testFlags, population, rate, duration, window)
+		},
+	}
+
+	cmd.Flags().IntVarP(&population, "population", "p", 20, "Number of pods to keep running")
+	cmd.Flags().Float64VarP(&rate, "rate", "", 1, "Pods replaced per second")
+	cmd.Flags().DurationVarP(&duration, "duration", "d", 10*time.Minute, "How long to churn for")
+	cmd.Flags().DurationVarP(&window, "window", "w", time.Minute, "How long each reported window is")
+	addTestFlags(cmd, testFlags)
+
+	return cmd
+}
+
+// LeakTest looks for resources the runtime leaks across pod lifecycles
+func LeakTest(testFlags *tests.TestFlags) *cobra.Command {
+
+	var (
+		cycles, pods, warmup int
+		settle               time.Duration
+		stateDirs            []string
+		cgroupParent         string
+	)
+	cmd := &cobra.Command{
+		Use:   "leak",
+		Short: "resource leak detection across repeated pod lifecycles",
+		Long:  "Run full create/stop/remove cycles and report the daemon fds, threads and RSS, mounts, network namespaces and cgroups of the runtimes pods and state directory entries that grow with the number of cycles. Exits non zero if anything grew in more than one cycle.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return tests.LeakTest(testFlags, cycles, pods, warmup, settle, stateDirs, cgroupParent)
+		},
+	}
+
+	cmd.Flags().IntVarP(&cycles, "cycles", "", 10, "Number of measured create/stop/remove cycles")
+	cmd.Flags().IntVarP(&pods, "pods", "p", 10, "Number of pods in each cycle")
+	cmd.Flags().IntVarP(&warmup, "warmup", "", 1, "Cycles to run before the baseline is taken")
+	cmd.Flags().DurationVarP(&settle, "settle", "", 5*time.Second, "How long to wait after each cycle before counting")
+	cmd.Flags().StringSliceVarP(&stateDirs, "state-dir", "", tests.DefaultStateDirs, "Runtime state directories to count the entries of")
+	cmd.Flags().StringVarP(&cgroupParent, "cgroup-parent", "", "", "Cgroup the runtime puts pods under, relative to the hierarchy root. Found from the first pods created if not set")
+	addTestFlags(cmd, testFlags)
+
+	return cmd
+}
+
+// ExecTest measures ExecSync latency against running containers
+func ExecTest(testFlags *tests.TestFlags) *cobra.Command {
+
+	var (
+		pods, concurrency int
+		duration, idle    time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "exec [flags] [-- command...]",
+		Short: "ExecSync latency against running containers",
+		Long:  "Create pods, then run ExecSync calls in their containers at a fixed concurrency for a duration. Reports round trip latency percentiles and the runtime cpu each exec costs over idle. The command defaults to true.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			if len(args) == 0 {
+				args = []string{"true"}
+			}
+			return tests.ExecTest(testFlags, pods, args, concurrency, duration, idle)
+		},
+	}
+
+	cmd.Flags().IntVarP(&pods, "pods", "p", 10, "Number of pods to exec in")
+	cmd.Flags().IntVarP(&concurrency, "concurrency", "", 10, "Number of execs in flight")
+	cmd.Flags().DurationVarP(&duration, "duration", "d", time.Minute, "How long to exec for")
+	cmd.Flags().DurationVarP(&idle, "idle", "", 10*time.Second, "How long to measure the runtimes idle cpu for before the execs")
+	addTestFlags(cmd, testFlags)
+
+	return cmd
+}
+
+// RestartTest restarts containers in place inside live sandboxes
+func RestartTest(testFlags *tests.TestFlags) *cobra.Command {
+
+	var pods, restarts int
+	cmd := &cobra.Command{
+		Use:   "restart",
+		Short: "container restart-in-place latency within existing sandboxes",
+		Long:  "Create pods, then repeatedly stop, remove and recreate every container with the next attempt number while the sandboxes stay up, the way the kubelet restarts a crashed container. Restart latency is reported separately from pod creation.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return tests.RestartTest(testFlags, pods, restarts)
+		},
+	}
+
+	cmd.Flags().IntVarP(&pods, "pods", "p", 10, "Number of pods whose containers are restarted")
+	cmd.Flags().IntVarP(&restarts, "restarts", "", 5, "How many times every container is restarted")
+	addTestFlags(cmd, testFlags)
+
+	return cmd
+}
+
+// DensityTest ramps the pod count to model the marginal cost of a pod
+func DensityTest(testFlags *tests.TestFlags) *cobra.Command {
+
+	var (
+		step, max int
+		settle    time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "density",
+		Short: "density ramp with a per pod marginal cost model",
+		Long:  "Raise the pod count in steps, letting the node settle at each one, and record runtime memory and cpu, node memory and create latency. The marginal memory and cpu of one more pod and the latency trend are fitted across the steps.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return tests.DensityTest(testFlags, step, max, settle)
+		},
+	}
+
+	cmd.Flags().IntVarP(&step, "step", "s", 10, "Pods added at each step")
+	cmd.Flags().IntVarP(&max, "max", "m", 100, "Pod count to ramp to")
+	cmd.Flags().DurationVarP(&settle, "settle", "", 30*time.Second, "How long to let the node settle at each step before measuring")
+	addTestFlags(cmd, testFlags)
+
+	return cmd
+}
+
+// addTestFlags adds the flags every test needs to find the runtime and the pods to run
+func addTestFlags(cmd *cobra.Command, testFlags *tests.TestFlags) {
+	cmd.Flags().StringVarP(&testFlags.OCIRuntime, "runtime", "", "/var/run/crio/crio.sock", "The location of the runtime socket to use")
 	cmd.Flags().StringVarP(&testFlags.CGroupPath, "cgroup-path", "", "/system.slice/crio.service", "Path to the cgroup")
-	cmd.Flags().StringVarP(&testFlags.PodConfigFile, "pod-configfile", "", "", "A file to use a custom pod spec")
 	cmd.Flags().IntVarP(&testFlags.Threads, "threads", "", 5, "how many concurant threads to use.")
+	addConfigFlags(cmd, testFlags)
+}
+
+// addConfigFlags adds the flags that replace the compiled in default configs
+func addConfigFlags(cmd *cobra.Command, testFlags *tests.TestFlags) {
+	cmd.Flags().StringVarP(&testFlags.PodConfigFile, "pod-configfile", "", "", "A file to use a custom pod spec, defaults to the built in pod")
+	cmd.Flags().StringVarP(&testFlags.SandboxConfigFile, "sandbox-config", "", "", "A json pod sandbox config to use as the base for every pod, defaults to the built in config")
+	cmd.Flags().StringVarP(&testFlags.ContainerConfigFile, "container-config", "", "", "A json container config to use as the base for every container, defaults to the built in config")
+	addWorkloadFlags(cmd, testFlags)
+}
+
+// addWorkloadFlags adds the flags that pick a built in workload and set its parameters
+func addWorkloadFlags(cmd *cobra.Command, testFlags *tests.TestFlags) {
+	defaults := workloads.DefaultParams()
+	p := &testFlags.WorkloadParams
+	cmd.Flags().StringVarP(&testFlags.Workload, "workload", "", "", "A built in workload to run instead of a pod spec file, one of:\n"+workloads.Usage())
+	cmd.Flags().StringVarP(&p.Image, "workload-image", "", defaults.Image, "The image the built in workloads run")
+	cmd.Flags().IntVarP(&p.CPUs, "workload-cpus", "", defaults.CPUs, "How many cpus the cpu workload burns")
+	cmd.Flags().StringVarP(&p.Memory, "workload-memory", "", defaults.Memory, "How much memory the memory workload allocates")
+	cmd.Flags().StringVarP(&p.WriteSize, "workload-write-size", "", defaults.WriteSize, "How much the disk workload writes every interval")
+	cmd.Flags().DurationVarP(&p.Interval, "workload-interval", "", defaults.Interval, "How often the disk workload writes")
+	cmd.Flags().IntVarP(&p.LogRate, "workload-log-rate", "", defaults.LogRate, "How many lines a second the logs workload writes")
+	cmd.Flags().IntVarP(&p.LogLineSize, "workload-log-line-size", "", defaults.LogLineSize, "How long each line of the logs workload is")
+	cmd.Flags().IntVarP(&p.Port, "workload-port", "", defaults.Port, "The port the echo workload listens on")
+	cmd.Flags().StringVarP(&p.MemoryLimit, "workload-memory-limit", "", "", "A memory limit for the built in workloads, none by default")
+}
+
+// LoggingTest measures container log throughput and what logging costs the runtime
+func LoggingTest(testFlags *tests.TestFlags) *cobra.Command {
+
+	var (
+		pods     int
+		duration time.Duration
+		logDir   string
+	)
+	cmd := &cobra.Command{
+		Use:   "logging",
+		Short: "container log throughput and logging overhead",
+		Long:  "Run the logs workload at --workload-log-rate lines a second of --workload-log-line-size bytes, with the runtime writing CRI log files under --log-dir. The log files are parsed for the throughput achieved, dropped and partial lines, and the cpu the runtime and its shims (conmon, containerd-shim) spent.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return tests.LoggingTest(testFlags, pods, duration, logDir)
+		},
+	}
+
+	cmd.Flags().IntVarP(&pods, "pods", "p", 10, "Number of pods logging at once")
+	cmd.Flags().DurationVarP(&duration, "duration", "d", time.Minute, "How long to measure logging for")
+	cmd.Flags().StringVarP(&logDir, "log-dir", "", tests.DefaultLogDirectory, "Where the runtime writes the pod logs, a directory per run that is removed afterwards")
+	addTestFlags(cmd, testFlags)
+
+	return cmd
+}
+
+// UpdateTest resizes running containers with UpdateContainerResources
+func UpdateTest(testFlags *tests.TestFlags) *cobra.Command {
+
+	var (
+		pods, rounds int
+		cpus, memory []string
+	)
+	cmd := &cobra.Command{
+		Use:   "update",
+		Short: "UpdateContainerResources latency and cgroup enforcement",
+		Long:  "Create pods, then repeatedly resize the cpu and memory limits of every container, going through --cpus and --memory in turn each round. Each update is timed and the containers cgroup (memory.max and cpu.max, or memory.limit_in_bytes and cpu.cfs_quota_us on v1) is read back to check the new limits were applied.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return tests.UpdateTest(testFlags, pods, rounds, cpus, memory)
+		},
+	}
+
+	cmd.Flags().IntVarP(&pods, "pods", "p", 10, "Number of pods whose containers are resized")
+	cmd.Flags().IntVarP(&rounds, "rounds", "", 10, "How many times every container is resized")
+	cmd.Flags().StringSliceVarP(&cpus, "cpus", "", []string{"500m", "1"}, "The cpu limits to go through")
+	cmd.Flags().StringSliceVarP(&memory, "memory", "", []string{"128Mi", "256Mi"}, "The memory limits to go through")
+	addTestFlags(cmd, testFlags)
+
+	return cmd
+}
+
+// OOMTest runs containers over their memory limit to see how the runtime reports OOM kills
+func OOMTest(testFlags *tests.TestFlags) *cobra.Command {
+
+	var (
+		pods            int
+		allocate, limit string
+		timeout         time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "oom",
+		Short: "OOM kill detection and reporting",
+		Long:  "Run the memory workload allocating --allocate under a --limit memory limit so every container is OOM killed. Reports the time from the container starting to the runtime finishing it and to ContainerStatus showing it exited, the exit codes and reasons, and the oom_kill count of the containers cgroups. Exits non zero unless every container was reported as OOMKilled.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return tests.OOMTest(testFlags, pods, allocate, limit, timeout)
+		},
+	}
+
+	cmd.Flags().IntVarP(&pods, "pods", "p", 10, "Number of pods to OOM")
+	cmd.Flags().StringVarP(&allocate, "allocate", "", "256Mi", "How much memory each container tries to allocate")
+	cmd.Flags().StringVarP(&limit, "limit", "", "64Mi", "The containers memory limit")
+	cmd.Flags().DurationVarP(&timeout, "timeout", "", time.Minute, "How long to wait for each container to be killed")
+	addTestFlags(cmd, testFlags)
+
+	return cmd
+}
+
+// StorageTest tracks writable layer and image filesystem usage while containers write
+func StorageTest(testFlags *tests.TestFlags) *cobra.Command {
+
+	var (
+		pods             int
+		duration, sample time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "storage",
+		Short: "writable layer and image filesystem growth",
+		Long:  "Run the disk workload, writing --workload-write-size every --workload-interval, and sample every containers writable layer from ContainerStats and the image filesystem from ImageFsInfo, bytes and inodes, every --sample for --duration. Useful to compare snapshotters and storage drivers.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return tests.StorageTest(testFlags, pods, duration, sample)
+		},
+	}
+
+	cmd.Flags().IntVarP(&pods, "pods", "p", 10, "Number of pods writing at once")
+	cmd.Flags().DurationVarP(&duration, "duration", "d", 5*time.Minute, "How long to sample for")
+	cmd.Flags().DurationVarP(&sample, "sample", "", 30*time.Second, "How often to sample storage")
+	addTestFlags(cmd, testFlags)
+
+	return cmd
+}
+
+// StreamingTest benchmarks the exec, attach and port-forward streams of the runtimes streaming server
+func StreamingTest(testFlags *tests.TestFlags) *cobra.Command {
+
+	var (
+		pods                    int
+		concurrency             []int
+		execBytes, forwardBytes int64
+	)
+	cmd := &cobra.Command{
+		Use:   "streaming",
+		Short: "exec, attach and port-forward stream latency and throughput",
+		Long:  "Run the echo workload and, at each --concurrency, open that many exec, port-forward and attach streams at once through the runtimes streaming server. Exec streams read --exec-bytes from stdout, port-forwards send --forward-bytes to the echo server and read them back. Reports setup, connect and first byte latency, failures and throughput.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return tests.StreamingTest(testFlags, pods, concurrency, execBytes, forwardBytes)
+		},
+	}
+
+	cmd.Flags().IntVarP(&pods, "pods", "p", 10, "Number of pods to spread the streams over")
+	cmd.Flags().IntSliceVarP(&concurrency, "concurrency", "", []int{1, 10, 50}, "Numbers of streams of each kind to open at once")
+	cmd.Flags().Int64VarP(&execBytes, "exec-bytes", "", 64*1024*1024, "Bytes each exec stream reads from stdout")
+	cmd.Flags().Int64VarP(&forwardBytes, "forward-bytes", "", 16*1024*1024, "Bytes each port-forward sends and reads back")
+	addTestFlags(cmd, testFlags)
+
+	return cmd
+}
+
+// ResilienceTest measures how the runtime and its pods come through a restart of the runtime daemon
+func ResilienceTest(testFlags *tests.TestFlags) *cobra.Command {
+
+	var (
+		pods            int
+		duration, probe time.Duration
+		restartCommand  string
+	)
+	cmd := &cobra.Command{
+		Use:   "resilience",
+		Short: "runtime downtime and pod survival across a daemon restart",
+		Long:  "Start pods, then probe the runtime every --probe for up to --duration while it is restarted, by --restart-command or by hand. Reports how long the CRI socket was unavailable, when the connection dropped and whether the pods created before the restart are still listed by ListPodSandbox and their containers still running.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return tests.ResilienceTest(testFlags, pods, duration, probe, restartCommand)
+		},
+	}
+
+	cmd.Flags().IntVarP(&pods, "pods", "p", 10, "Number of pods to start before the restart")
+	cmd.Flags().DurationVarP(&duration, "duration", "d", 5*time.Minute, "How long to wait for the runtime to be restarted and come back")
+	cmd.Flags().DurationVarP(&probe, "probe", "", 100*time.Millisecond, "How often to probe the runtime")
+	cmd.Flags().StringVarP(&restartCommand, "restart-command", "", "", "Command run with sh -c to restart the runtime once the pods are started, such as \"systemctl restart crio\"")
+	addTestFlags(cmd, testFlags)
 
 	return cmd
 }
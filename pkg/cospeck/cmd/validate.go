@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"github.com/Klaven/cospeck/internal/tests"
+	"github.com/spf13/cobra"
+)
+
+func validateCmd(flags *Flags, testFlags *tests.TestFlags) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check the pod spec and runtime before a run",
+		Long:  "Parse the pod spec and report fields that are misspelt or ignored, check every image through ImageStatus, confirm the socket speaks CRI and that the cgroup path exists.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return tests.Validate(testFlags)
+		},
+	}
+
+	addTestFlags(cmd, testFlags)
+
+	return cmd
+}